@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserPatch - изменяемые через API поля подписки. nil - не менять.
+type UserPatch struct {
+	ExcludedCategories *[]string `json:"excluded_categories"`
+	IsAdmin            *bool     `json:"is_admin"`
+	Deactivated        *bool     `json:"deactivated"`
+}
+
+// StatePatch - изменяемые через API поля State. nil - не менять.
+type StatePatch struct {
+	LastDate   *time.Time `json:"last_date"`
+	Categories *[]string  `json:"categories"`
+}
+
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Log.Error(`Can't write response`, `error`, err.Error())
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, ApiError{Error: err.Error()})
+}
+
+func (core *Core) VerifyApiRequest(r *http.Request) bool {
+	token := core.ConfigFile.Get().Api.Token
+	if token == `` {
+		return false
+	}
+	authorization := r.Header.Get(`Authorization`)
+	if !strings.HasPrefix(authorization, `Bearer `) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, `Bearer `)), []byte(token)) == 1
+}
+
+// ApiHttpHandler - REST API для администрирования:
+//
+//	GET   /api/users
+//	GET   /api/users/<id>
+//	PATCH /api/users/<id>             UserPatch
+//	POST  /api/users/<id>/deactivate
+//	GET   /api/state
+//	PATCH /api/state                  StatePatch
+//	POST  /api/fetch                  забрать фид и разослать новые итемы
+func (core *Core) ApiHttpHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(w, r).With(`handler`, `api`)
+	logger.Debug(`Request`, `method`, r.Method, `uri`, r.RequestURI, `ip`, core.RemoteIp(r).String())
+	if !core.VerifyApiRequest(r) {
+		PrometheusRejectedRequests.With(prometheus.Labels{`handler`: `api`, `reason`: `auth`}).Inc()
+		writeJsonError(w, http.StatusUnauthorized, errors.New(`unauthorized`))
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, `/api`), `/`), `/`)
+	switch {
+	case parts[0] == `users` && len(parts) == 1 && r.Method == http.MethodGet:
+		core.ApiGetUsers(w)
+	case parts[0] == `users` && len(parts) == 2:
+		core.ApiUser(w, r, parts[1])
+	case parts[0] == `users` && len(parts) == 3 && parts[2] == `deactivate` && r.Method == http.MethodPost:
+		core.ApiDeactivateUser(w, parts[1])
+	case parts[0] == `state` && len(parts) == 1:
+		core.ApiState(w, r)
+	case parts[0] == `fetch` && len(parts) == 1 && r.Method == http.MethodPost:
+		core.ApiFetch(w, logger)
+	default:
+		writeJsonError(w, http.StatusNotFound, errors.New(`not found`))
+	}
+}
+
+// apiGetUser возвращает подписку по ID из URL. Если её нет - отвечает ошибкой сам и возвращает nil.
+func (core *Core) apiGetUser(w http.ResponseWriter, id string) *User {
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	user, err := core.GetUser(userId)
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return nil
+	}
+	if user.Id() == 0 {
+		writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+		return nil
+	}
+	return user
+}
+
+func (core *Core) ApiGetUsers(w http.ResponseWriter) {
+	users, err := core.GetUsers()
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, users)
+}
+
+func (core *Core) ApiUser(w http.ResponseWriter, r *http.Request, id string) {
+	user := core.apiGetUser(w, id)
+	if user == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		patch := UserPatch{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		if patch.ExcludedCategories != nil {
+			user.ExcludedCategories = *patch.ExcludedCategories
+		}
+		if patch.IsAdmin != nil {
+			user.IsAdmin = *patch.IsAdmin
+		}
+		if patch.Deactivated != nil {
+			user.Deactivated = *patch.Deactivated
+		}
+		if err := user.Save(); err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		Log.Info(`User updated via API`, `user_id`, user.Id(), `name`, user.Name())
+	default:
+		writeJsonError(w, http.StatusMethodNotAllowed, errors.New(`method not allowed`))
+		return
+	}
+	writeJson(w, http.StatusOK, user)
+}
+
+func (core *Core) ApiDeactivateUser(w http.ResponseWriter, id string) {
+	user := core.apiGetUser(w, id)
+	if user == nil {
+		return
+	}
+	if err := user.SetDeactivated(true); err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	Log.Info(`User deactivated via API`, `user_id`, user.Id(), `name`, user.Name())
+	writeJson(w, http.StatusOK, user)
+}
+
+func (core *Core) ApiState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		patch := StatePatch{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		core.State.Patch(patch.LastDate, patch.Categories)
+		if err := core.State.Save(); err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		Log.Info(`State updated via API`)
+	default:
+		writeJsonError(w, http.StatusMethodNotAllowed, errors.New(`method not allowed`))
+		return
+	}
+	writeJson(w, http.StatusOK, core.State)
+}
+
+// ApiFetch запускает получение фида в фоне: рассылка может занять долго.
+func (core *Core) ApiFetch(w http.ResponseWriter, logger *slog.Logger) {
+	if !core.Background.Go(func() { core.FetchFeed(logger) }) {
+		writeJsonError(w, http.StatusServiceUnavailable, errors.New(`shutting down`))
+		return
+	}
+	writeJson(w, http.StatusAccepted, struct {
+		Url string `json:"url"`
+	}{Url: core.ConfigFile.Get().Rss.Url})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ArticleFetchTimeout = 10 * time.Second
+	// ArticleMessageLength - лимит Telegram на длину сообщения 4096 символов, оставляем запас.
+	ArticleMessageLength = 4000
+)
+
+// articleTextSelectors - где искать текст статьи, по порядку. Первый - разметка auto.onliner.by. Все абзацы страницы
+// не берем: туда попадут комментарии, подвал и баннеры. Не нашли - статьи нет, читать предлагаем по ссылке.
+var articleTextSelectors = []string{`.news-text p`, `article p`}
+
+type Article struct {
+	Url       string
+	Title     string
+	Text      string // абзацы через пустую строку
+	Image     string
+	FetchedAt time.Time `yaml:"fetched_at"`
+}
+
+func (core *Core) ArticlePath(itemId int) string {
+	return path.Join(core.ConfigFile.Get().BaseDir, `articles`, fmt.Sprintf("%d.yml", itemId))
+}
+
+// LoadArticle читает статью из кеша.
+func (core *Core) LoadArticle(itemId int) (*Article, error) {
+	f, err := os.Open(core.ArticlePath(itemId))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	article := Article{}
+	if err := yaml.NewDecoder(f).Decode(&article); err != nil {
+		Log.Error(`Can't load article`, `item_id`, itemId, `error`, err.Error())
+		return nil, err
+	}
+	return &article, nil
+}
+
+func (core *Core) SaveArticle(itemId int, article *Article) error {
+	f, err := os.OpenFile(core.ArticlePath(itemId), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		Log.Error(`Can't save article`, `item_id`, itemId, `error`, err.Error())
+		return err
+	}
+	defer f.Close()
+	if err := yaml.NewEncoder(f).Encode(article); err != nil {
+		Log.Error(`Can't save article`, `item_id`, itemId, `error`, err.Error())
+		return err
+	}
+	return nil
+}
+
+// RemoveArticle удаляет статью из кеша, когда её итем вытеснен из History: читать её уже нельзя.
+func (core *Core) RemoveArticle(itemId int) {
+	if err := os.Remove(core.ArticlePath(itemId)); err != nil && !os.IsNotExist(err) {
+		Log.Error(`Can't remove article`, `item_id`, itemId, `error`, err.Error())
+		PrometheusErrors.With(prometheus.Labels{`action`: `remove_article`}).Inc()
+	}
+}
+
+// GetArticle возвращает статью из кеша, а если её там нет - скачивает и кладет в кеш.
+func (core *Core) GetArticle(item HistoryItem) (*Article, error) {
+	if article, err := core.LoadArticle(item.Id); err == nil {
+		return article, nil
+	}
+	article, err := FetchArticle(item.Link)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `fetch_article`}).Inc()
+		return nil, err
+	}
+	if article.Title == `` {
+		article.Title = item.Title
+	}
+	core.SaveArticle(item.Id, article)
+	return article, nil
+}
+
+// FetchArticle скачивает страницу и вытаскивает из неё текст статьи и главную картинку.
+func FetchArticle(url string) (*Article, error) {
+	client := http.Client{Timeout: ArticleFetchTimeout}
+	response, err := client.Get(url)
+	if err != nil {
+		Log.Error(`Can't fetch article`, `url`, url, `error`, err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		err := errors.New(response.Status)
+		Log.Error(`Can't fetch article`, `url`, url, `error`, err.Error())
+		return nil, err
+	}
+	document, err := goquery.NewDocumentFromReader(response.Body)
+	if err != nil {
+		Log.Error(`Can't parse article`, `url`, url, `error`, err.Error())
+		return nil, err
+	}
+
+	article := Article{
+		Url:       url,
+		FetchedAt: time.Now(),
+	}
+	article.Title, _ = document.Find(`meta[property="og:title"]`).Attr(`content`)
+	article.Image, _ = document.Find(`meta[property="og:image"]`).Attr(`content`)
+	for _, selector := range articleTextSelectors {
+		paragraphs := make([]string, 0)
+		document.Find(selector).Each(func(_ int, selection *goquery.Selection) {
+			if text := strings.Join(strings.Fields(selection.Text()), ` `); text != `` {
+				paragraphs = append(paragraphs, text)
+			}
+		})
+		if len(paragraphs) > 0 {
+			article.Text = strings.Join(paragraphs, "\n\n")
+			break
+		}
+	}
+	if article.Text == `` {
+		err := errors.New(`article text not found`)
+		Log.Warn(`Can't find article text`, `url`, url)
+		return nil, err
+	}
+	return &article, nil
+}
+
+// splitArticle режет текст на сообщения не длиннее ArticleMessageLength символов, по возможности по абзацам.
+func splitArticle(text string) []string {
+	messages := make([]string, 0)
+	current := ``
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		for len([]rune(paragraph)) > ArticleMessageLength {
+			if current != `` {
+				messages = append(messages, current)
+				current = ``
+			}
+			runes := []rune(paragraph)
+			messages = append(messages, string(runes[:ArticleMessageLength]))
+			paragraph = string(runes[ArticleMessageLength:])
+		}
+		if current != `` && len([]rune(current))+2+len([]rune(paragraph)) > ArticleMessageLength {
+			messages = append(messages, current)
+			current = ``
+		}
+		if current != `` {
+			current = current + "\n\n"
+		}
+		current = current + paragraph
+	}
+	if current != `` {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// ReadCallback - кнопка "📖 Читать здесь": присылаем текст статьи в личку тому, кто нажал.
+func (core *Core) ReadCallback(update Update, data string) {
+	callbackId := update.CallbackQuery.Id
+	language := core.ChatLanguage(update.CallbackQuery.From.Id, update.CallbackQuery.From)
+	itemId, err := strconv.Atoi(data)
+	if err != nil {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `unknown_command`), true)
+		return
+	}
+	item, ok := core.History.Get(itemId)
+	if !ok {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `article_too_old`), true)
+		return
+	}
+	article, err := core.GetArticle(item)
+	if err != nil {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `article_failed`), true)
+		return
+	}
+	chatId := update.CallbackQuery.From.Id
+	messages := splitArticle(article.Text)
+	messages[0] = article.Title + "\n\n" + messages[0]
+	for i, text := range messages {
+		message := telegram.SendMessageIntWithoutReplyMarkup{}
+		message.ChatId = chatId
+		message.Text = text
+		message.DisableWebPagePreview = true
+		if err := core.TelegramApi.RequestWrapper(``, message, nil); err != nil {
+			PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+			if i == 0 {
+				core.AnswerCallback(callbackId, core.Catalog.Text(language, `article_start_required`, core.BotUsername), true)
+				return
+			}
+			break
+		}
+	}
+	core.AnswerCallback(callbackId, ``, false)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bookmarksButtonsInRow - кнопок удаления закладок в одном ряду.
+const bookmarksButtonsInRow = 5
+
+// BookmarksPageSize - закладок на одной странице /saved. Заголовки со ссылками должны с запасом уложиться в 4096
+// символов сообщения Telegram.
+const BookmarksPageSize = 10
+
+// SavedMessage - текст, кнопки удаления и листания для страницы page списка закладок.
+func (core *Core) SavedMessage(user *User, page int) (string, telegram.InlineKeyboardMarkup) {
+	markup := telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{}}
+	if len(user.Bookmarks) == 0 {
+		return core.Catalog.Text(user.Lang(), `bookmarks_empty`), markup
+	}
+	pages := (len(user.Bookmarks) + BookmarksPageSize - 1) / BookmarksPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	text := core.Catalog.Text(user.Lang(), `bookmarks_title`) + "\n"
+	if pages > 1 {
+		text = core.Catalog.Text(user.Lang(), `bookmarks_title_page`, page+1, pages) + "\n"
+	}
+	row := make([]telegram.InlineKeyboardButton, 0)
+	from := page * BookmarksPageSize
+	for i := from; i < len(user.Bookmarks) && i < from+BookmarksPageSize; i++ {
+		bookmark := user.Bookmarks[i]
+		text = text + fmt.Sprintf("\n%d. %s\n%s\n", i+1, bookmark.Title, bookmark.Link)
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         fmt.Sprintf("❌ %d", i+1),
+			CallbackData: fmt.Sprintf("unsave|%d|%d", bookmark.ItemId, page),
+		})
+		if len(row) == bookmarksButtonsInRow {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+			row = make([]telegram.InlineKeyboardButton, 0)
+		}
+	}
+	if len(row) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+	buttons := make([]telegram.InlineKeyboardButton, 0)
+	if page > 0 {
+		buttons = append(buttons, telegram.InlineKeyboardButton{Text: `◀️`, CallbackData: fmt.Sprintf("saved|%d", page-1)})
+	}
+	if page < pages-1 {
+		buttons = append(buttons, telegram.InlineKeyboardButton{Text: `▶️`, CallbackData: fmt.Sprintf("saved|%d", page+1)})
+	}
+	if len(buttons) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, buttons)
+	}
+	return text, markup
+}
+
+// TelegramSaved - /saved. Закладки личные, поэтому только в личке.
+func (core *Core) TelegramSaved(message telegram.Message) {
+	language := core.ChatLanguage(message.Chat.Id, message.From)
+	if !isPrivateChat(message.Chat) {
+		core.SendText(message.Chat.Id, core.Catalog.Text(language, `bookmarks_private_only`))
+		return
+	}
+	user, err := core.GetOrCreateChat(message.Chat, message.From)
+	if err != nil {
+		core.SendText(message.Chat.Id, core.Catalog.Text(language, `error`))
+		return
+	}
+	text, markup := core.SavedMessage(user, 0)
+	payload := telegram.SendMessageIntWithInlineKeyboardMarkup{
+		ReplyMarkup: markup,
+	}
+	payload.ChatId = user.Id()
+	payload.Text = text
+	payload.DisableWebPagePreview = true
+	if err := core.TelegramApi.RequestWrapper(``, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
+
+func (core *Core) editSaved(update Update, user *User, page int) {
+	text, markup := core.SavedMessage(user, page)
+	core.EditText(EditMessageText{
+		ChatId:                update.CallbackQuery.Message.Chat.Id,
+		MessageId:             update.CallbackQuery.Message.Id,
+		Text:                  text,
+		DisableWebPagePreview: true,
+		ReplyMarkup:           &markup,
+	})
+}
+
+// BookmarkCallback - save|<id> под итемом, unsave|<id>|<страница> и saved|<страница> в списке /saved. Закладка
+// сохраняется тому, кто нажал кнопку, даже если итем пришел в группу.
+func (core *Core) BookmarkCallback(update Update, action, data string) {
+	callbackId := update.CallbackQuery.Id
+	// Отвечаем на языке того, кто нажал, а не чата, куда пришел итем
+	language := core.ChatLanguage(update.CallbackQuery.From.Id, update.CallbackQuery.From)
+	// saved|<страница>; unsave из старых сообщений /saved приходит без страницы
+	var itemId, page int
+	parts := strings.SplitN(data, `|`, 2)
+	number, err := strconv.Atoi(parts[0])
+	if action == `saved` {
+		page = number
+	} else {
+		itemId = number
+	}
+	if err == nil && len(parts) == 2 {
+		page, err = strconv.Atoi(parts[1])
+	}
+	if err != nil {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `unknown_command`), true)
+		return
+	}
+	user, err := core.GetUser(update.CallbackQuery.From.Id)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `error`), true)
+		return
+	}
+	if user.Id() == 0 {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_start_required`, core.BotUsername), true)
+		return
+	}
+
+	if action == `saved` {
+		core.AnswerCallback(callbackId, ``, false)
+		core.editSaved(update, user, page)
+		return
+	}
+
+	if action == `unsave` {
+		if err := user.RemoveBookmark(itemId); err != nil && !errors.Is(err, ErrNotBookmarked) {
+			PrometheusErrors.With(prometheus.Labels{`action`: `bookmark`}).Inc()
+			core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_remove_failed`), true)
+			return
+		}
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_removed`), false)
+		core.editSaved(update, user, page)
+		return
+	}
+
+	item, ok := core.History.Get(itemId)
+	if !ok {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_too_old`), true)
+		return
+	}
+	err = user.AddBookmark(Bookmark{
+		ItemId:  item.Id,
+		Title:   item.Title,
+		Link:    item.Link,
+		SavedAt: time.Now(),
+	})
+	switch {
+	case err == nil:
+		Log.Debug(`Bookmarked`, `user_id`, user.Id(), `item_id`, item.Id)
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_saved`), false)
+	case errors.Is(err, ErrBookmarked):
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_already_saved`), false)
+	default:
+		PrometheusErrors.With(prometheus.Labels{`action`: `bookmark`}).Inc()
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `bookmark_save_failed`), true)
+	}
+}
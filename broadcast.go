@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// broadcastProgressStep - через сколько отправленных сообщений обновлять прогресс у админа.
+const broadcastProgressStep = 10
+
+// BroadcastTtl - сколько ждать подтверждения рассылки.
+const BroadcastTtl = time.Hour
+
+type broadcast struct {
+	adminId   int
+	text      string
+	createdAt time.Time
+}
+
+// Broadcasts хранит подготовленные, но еще не подтвержденные рассылки по ID, который передается в callback_data:
+// кнопка подтверждает ровно ту рассылку, предпросмотр которой над ней показан.
+type Broadcasts struct {
+	mutex      sync.Mutex
+	lastId     int
+	broadcasts map[int]broadcast
+}
+
+// Add запоминает рассылку, попутно удаляя устаревшие, и возвращает её ID.
+func (broadcasts *Broadcasts) Add(adminId int, text string) int {
+	broadcasts.mutex.Lock()
+	defer broadcasts.mutex.Unlock()
+	if broadcasts.broadcasts == nil {
+		broadcasts.broadcasts = make(map[int]broadcast)
+	}
+	now := time.Now()
+	for id, b := range broadcasts.broadcasts {
+		if now.Sub(b.createdAt) > BroadcastTtl {
+			delete(broadcasts.broadcasts, id)
+		}
+	}
+	broadcasts.lastId++
+	broadcasts.broadcasts[broadcasts.lastId] = broadcast{adminId: adminId, text: text, createdAt: now}
+	return broadcasts.lastId
+}
+
+// Pop возвращает и забывает рассылку, чтобы повторное нажатие кнопки не отправило её дважды. Подтвердить её может
+// только тот админ, который её подготовил.
+func (broadcasts *Broadcasts) Pop(id, adminId int) (string, bool) {
+	broadcasts.mutex.Lock()
+	defer broadcasts.mutex.Unlock()
+	b, ok := broadcasts.broadcasts[id]
+	if !ok || b.adminId != adminId {
+		return ``, false
+	}
+	delete(broadcasts.broadcasts, id)
+	if time.Since(b.createdAt) > BroadcastTtl {
+		return ``, false
+	}
+	return b.text, true
+}
+
+// TelegramBroadcast - /broadcast <текст>: показываем админу предпросмотр и спрашиваем подтверждение.
+func (core *Core) TelegramBroadcast(message telegram.Message) {
+	user, err := core.GetUser(message.Chat.Id)
+	if err != nil || !user.IsPrivate() || !user.IsAdmin {
+		Log.Debug(`Not allowed to broadcast`, `user_id`, message.From.Id)
+		return
+	}
+	_, text := parseCommand(message.Text, core.BotUsername)
+	if text == `` {
+		core.SendText(user.Id(), core.Catalog.Text(user.Lang(), `broadcast_usage`))
+		return
+	}
+	broadcastId := core.Broadcasts.Add(user.Id(), text)
+	payload := telegram.SendMessageIntWithInlineKeyboardMarkup{
+		ReplyMarkup: telegram.InlineKeyboardMarkup{
+			InlineKeyboard: [][]telegram.InlineKeyboardButton{{
+				{Text: core.Catalog.Text(user.Lang(), `broadcast_send`), CallbackData: fmt.Sprintf("broadcast|send|%d", broadcastId)},
+				{Text: core.Catalog.Text(user.Lang(), `broadcast_cancel`), CallbackData: fmt.Sprintf("broadcast|cancel|%d", broadcastId)},
+			}},
+		},
+	}
+	payload.ChatId = user.Id()
+	payload.Text = core.Catalog.Text(user.Lang(), `broadcast_confirm`, text)
+	if err := core.TelegramApi.RequestWrapper(``, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
+
+// BroadcastCallback - broadcast|send|<id> и broadcast|cancel|<id> под предпросмотром.
+func (core *Core) BroadcastCallback(update Update, user *User, data string) {
+	callbackId := update.CallbackQuery.Id
+	if !user.IsPrivate() || !user.IsAdmin {
+		core.AnswerCallback(callbackId, core.Catalog.Text(user.Lang(), `broadcast_admins_only`), true)
+		return
+	}
+	action, id, _ := strings.Cut(data, `|`)
+	broadcastId, err := strconv.Atoi(id)
+	if err != nil {
+		// Кнопки из старых предпросмотров без ID
+		core.AnswerCallback(callbackId, core.Catalog.Text(user.Lang(), `broadcast_stale`), true)
+		return
+	}
+	text, ok := core.Broadcasts.Pop(broadcastId, user.Id())
+	if !ok {
+		core.AnswerCallback(callbackId, core.Catalog.Text(user.Lang(), `broadcast_stale`), true)
+		return
+	}
+	progress := EditMessageText{
+		ChatId:    update.CallbackQuery.Message.Chat.Id,
+		MessageId: update.CallbackQuery.Message.Id,
+	}
+	if action != `send` {
+		progress.Text = core.Catalog.Text(user.Lang(), `broadcast_cancelled`)
+		core.AnswerCallback(callbackId, progress.Text, false)
+		core.EditText(progress)
+		return
+	}
+	core.AnswerCallback(callbackId, core.Catalog.Text(user.Lang(), `broadcast_started`), false)
+	Log.Info(`Broadcast started`, `user_id`, user.Id(), `name`, user.Name(), `text`, text)
+
+	users, err := core.GetUsers()
+	if err != nil {
+		progress.Text = core.Catalog.Text(user.Lang(), `broadcast_users_failed`, err.Error())
+		core.EditText(progress)
+		return
+	}
+	recipients := make([]*User, 0)
+	for _, u := range users {
+		if u.IsActive() {
+			recipients = append(recipients, u)
+		}
+	}
+	sent, failed := 0, 0
+	for i, recipient := range recipients {
+		if i%broadcastProgressStep == 0 {
+			progress.Text = core.Catalog.Text(user.Lang(), `broadcast_progress`, i, len(recipients))
+			core.EditText(progress)
+		}
+		err := core.Deliver(context.Background(), recipient, text, nil)
+		core.SendPause()
+		if err != nil {
+			failed++
+			continue
+		}
+		sent++
+	}
+	Log.Info(`Broadcast finished`, `sent`, sent, `failed`, failed)
+	progress.Text = core.Catalog.Text(user.Lang(), `broadcast_done`, sent, len(recipients), failed)
+	core.EditText(progress)
+}
+
+func (core *Core) EditText(payload EditMessageText) {
+	err := core.TelegramApi.RequestWrapper(`editMessageText`, payload, nil)
+	if err != nil && !isMessageNotModified(err) {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
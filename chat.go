@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+)
+
+// GetChatMember запрашивает статус пользователя в чате. RequestWrapper результат не возвращает, поэтому разбираем
+// ответ сами.
+func (core *Core) GetChatMember(chatId, userId int) (ChatMember, error) {
+	payload, err := telegram.JsonEncode(GetChatMember{ChatId: chatId, UserId: userId})
+	if err != nil {
+		Log.Error(`Can't encode getChatMember`, `error`, err.Error())
+		return ChatMember{}, err
+	}
+	_, data, err := core.TelegramApi.DoWithRetry(`getChatMember`, payload)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+		return ChatMember{}, err
+	}
+	var response struct {
+		Ok          bool       `json:"ok"`
+		Description string     `json:"description"`
+		Result      ChatMember `json:"result"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		Log.Error(`Can't parse getChatMember`, `response`, string(data), `error`, err.Error())
+		return ChatMember{}, err
+	}
+	if !response.Ok {
+		err := errors.New(response.Description)
+		Log.Error(`getChatMember failed`, `chat_id`, chatId, `user_id`, userId, `error`, err.Error())
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+		return ChatMember{}, err
+	}
+	return response.Result, nil
+}
+
+// IsChatAdmin - может ли userId менять настройки подписки чата. В личке - всегда; в канал пишут только
+// администраторы, поэтому посты канала (без отправителя) тоже считаем админскими.
+func (core *Core) IsChatAdmin(chat telegram.Chat, userId int) bool {
+	if isPrivateChat(chat) {
+		return true
+	}
+	if chat.Type == `channel` && userId == 0 {
+		return true
+	}
+	chatMember, err := core.GetChatMember(chat.Id, userId)
+	if err != nil {
+		return false
+	}
+	return chatMember.IsAdmin()
+}
+
+// TelegramMyChatMember - бота добавили в группу/канал или удалили из них.
+func (core *Core) TelegramMyChatMember(chatMemberUpdated ChatMemberUpdated) {
+	chat := chatMemberUpdated.Chat
+	status := chatMemberUpdated.NewChatMember
+	Log.Info(`My status changed`, `chat_id`, chat.Id, `chat_type`, chat.Type, `user_id`, chatMemberUpdated.From.Id,
+		`old_status`, chatMemberUpdated.OldChatMember.Status, `status`, status.Status)
+	if isPrivateChat(chat) {
+		core.SetUserBlocked(chat.Id, !status.IsPresent())
+		return
+	}
+	// В канал бот может писать только будучи администратором
+	subscribed := status.IsPresent()
+	if chat.Type == `channel` {
+		subscribed = status.IsAdmin()
+	}
+	if !subscribed {
+		if err := core.RemoveUser(chat.Id); err != nil {
+			Log.Debug(`Can't remove user`, `chat_id`, chat.Id, `error`, err.Error())
+		}
+		return
+	}
+	user, err := core.GetOrCreateChat(chat, chatMemberUpdated.From)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		return
+	}
+	if chat.Type != `channel` && !chatMemberUpdated.OldChatMember.IsPresent() {
+		text := core.ConfigFile.Get().StartMessage
+		if text != `` {
+			text = text + "\n\n"
+		}
+		core.SendText(user.Id(), text+core.Catalog.Text(user.Lang(), `categories_hint`, core.BotUsername))
+	}
+}
+
+// SetUserBlocked - пользователь заблокировал/разблокировал бота. Подписку не удаляем, чтобы после разблокировки
+// сохранились его настройки.
+func (core *Core) SetUserBlocked(id int, blocked bool) {
+	user, err := core.GetUser(id)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		return
+	}
+	if user.Id() == 0 {
+		Log.Debug(`User is not subscribed`, `user_id`, id)
+		return
+	}
+	if blocked {
+		Log.Info(`Bot blocked`, `user_id`, user.Id(), `name`, user.Name())
+	} else {
+		Log.Info(`Bot unblocked`, `user_id`, user.Id(), `name`, user.Name())
+	}
+	if err := user.SetBlocked(blocked); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `save`}).Inc()
+	}
+}
+
+// MigrateChat - группа стала супергруппой, и у неё новый ID: в старый чат Telegram больше ничего не принимает.
+// Переносим подписку вместе с настройками под новый ID.
+func (core *Core) MigrateChat(oldId, newId int) {
+	user, err := core.GetUser(oldId)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		return
+	}
+	if user.Id() == 0 {
+		return
+	}
+	existing, err := core.GetUser(newId)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		return
+	}
+	// Если из новой супергруппы уже успели подписаться - оставляем её подписку
+	if existing.Id() == 0 {
+		user.path = core.UserPath(newId)
+		user.Chat.Id = newId
+		user.Chat.Type = `supergroup`
+		if err := user.Save(); err != nil {
+			return
+		}
+	}
+	if err := core.RemoveUser(oldId); err != nil {
+		Log.Error(`Can't remove migrated chat`, `chat_id`, oldId, `error`, err.Error())
+		PrometheusErrors.With(prometheus.Labels{`action`: `save`}).Inc()
+	}
+}
@@ -1,6 +1,15 @@
 package main
 
-import "strings"
+import (
+	"gopkg.in/yaml.v2"
+	"html"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
 
 // diveIntoCategories
 //
@@ -17,3 +26,59 @@ func diveIntoCategories(categories []string) []string {
 	}
 	return newCategories
 }
+
+// parseCommand
+//
+// Разбирает "/command@bot аргументы" на команду и аргументы. В группах команды могут быть адресованы другим ботам -
+// такие возвращаются пустыми.
+func parseCommand(text, botUsername string) (string, string) {
+	if !strings.HasPrefix(text, `/`) {
+		return ``, ``
+	}
+	command, args := text, ``
+	if i := strings.IndexAny(text, " \n"); i != -1 {
+		command, args = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	command, to, found := strings.Cut(command, `@`)
+	if found && !strings.EqualFold(to, botUsername) {
+		return ``, ``
+	}
+	return command, args
+}
+
+// stripHtml - описания в фиде приходят в HTML, для поиска и показа нужен только текст.
+func stripHtml(s string) string {
+	s = htmlTagRegexp.ReplaceAllString(s, ` `)
+	return strings.Join(strings.Fields(html.UnescapeString(s)), ` `)
+}
+
+// writeYamlAtomic пишет v во временный файл рядом с filePath и переименовывает его поверх filePath. Если процесс
+// упадет во время записи, останется прежняя версия файла, а не обрезанная.
+func writeYamlAtomic(filePath string, v interface{}) error {
+	f, err := os.CreateTemp(path.Dir(filePath), path.Base(filePath)+`.*.tmp`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // после Rename файла с этим именем уже нет
+	encoder := yaml.NewEncoder(f)
+	if err := encoder.Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filePath)
+}
@@ -1,11 +1,283 @@
 package main
 
+import (
+	"fmt"
+	"github.com/vvampirius/mygolibs/telegram"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EnvPrefix - переменные окружения ONLINER_BOT_* переопределяют значения из файла (см. Config.ApplyEnv).
+const EnvPrefix = `ONLINER_BOT_`
+
 type Config struct {
 	Listen   string
 	Telegram struct {
 		Token   string
 		Webhook string
+		Mode    string // webhook (по умолчанию) или polling
+		// ApiUrl - свой Bot API сервер (telegram-bot-api или мок), по умолчанию https://api.telegram.org
+		ApiUrl string `yaml:"api_url"`
+		// SecretToken передается в setWebhook и сверяется с заголовком X-Telegram-Bot-Api-Secret-Token. Если не задан,
+		// при каждом старте генерируется случайный
+		SecretToken string `yaml:"secret_token"`
+		// IpAllowlist - принимать апдейты только из AllowedNetworks (по умолчанию - сети Telegram)
+		IpAllowlist     bool     `yaml:"ip_allowlist"`
+		AllowedNetworks []string `yaml:"allowed_networks"`
+		// SendInterval - пауза между сообщениями при рассылке, чтобы не упереться в лимиты Telegram
+		SendInterval time.Duration `yaml:"send_interval"`
+		// Deprecated: RealIpHeader переехал на верхний уровень (real_ip_header), старое место читается для совместимости
+		RealIpHeader string `yaml:"real_ip_header"`
+	}
+	Rss struct {
+		Url string // откуда забирать фид по запросу (по умолчанию DefaultFeedUrl)
+		// Tokens - имя клиента: токен. Клиент передает его в заголовке "Authorization: Bearer <токен>"
+		Tokens map[string]string
+		// HmacSecrets - имя клиента: ключ. Клиент передает свое имя в X-Rss-Client, а HMAC-SHA256 тела запроса -
+		// в X-Rss-Signature ("sha256=<hex>")
+		HmacSecrets map[string]string `yaml:"hmac_secrets"`
+		MaxBodySize int64             `yaml:"max_body_size"` // в байтах, по умолчанию DefaultRssMaxBodySize
+		AuditLog    string            `yaml:"audit_log"`     // по умолчанию rss_audit.log в BaseDir
+	}
+	Api struct {
+		// Token для "Authorization: Bearer <токен>". Если пустой - API выключено.
+		Token string
+	}
+	// Dashboard - веб-интерфейс на /admin/ с basic auth. Если пароль пустой - выключен.
+	Dashboard struct {
+		Username string
+		Password string
+	}
+	// ItemTemplate - text/template сообщения с итемом (см. ItemTemplateData), по умолчанию DefaultItemTemplate
+	ItemTemplate string `yaml:"item_template"`
+	Article      struct {
+		// Enabled - скачивать статью целиком: она доступна в ItemTemplate и по кнопке "📖 Читать здесь"
+		Enabled bool
+	}
+	Log struct {
+		Level  string // debug, info (по умолчанию), warn, error
+		Format string // text (по умолчанию) или json
+	}
+	// Tracing - OpenTelemetry. Exporter: otlp (OTLP/HTTP на Endpoint, например localhost:4318) или stdout для
+	// отладки. Пустой - трейсинг выключен.
+	Tracing struct {
+		Exporter string
+		Endpoint string
+		Insecure bool // http вместо https
 	}
+	Health struct {
+		// MaxIngestAge - /healthz отвечает ошибкой, если фид не приходил дольше (по умолчанию 2 часа)
+		MaxIngestAge time.Duration `yaml:"max_ingest_age"`
+	}
+	// ShutdownTimeout - сколько при остановке ждать незаконченные рассылки
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// RealIpHeader - заголовок с адресом клиента, если бот стоит за reverse proxy (например X-Real-IP). Из
+	// X-Forwarded-For берется последний адрес, то есть бот должен стоять прямо за этим proxy.
+	RealIpHeader string `yaml:"real_ip_header"`
 	BaseDir      string `yaml:"base_dir"`
 	StartMessage string `yaml:"start_message"`
 }
+
+// SetDefaults заполняет незаданные в файле значения.
+func (config *Config) SetDefaults() {
+	if config.RealIpHeader == `` && config.Telegram.RealIpHeader != `` {
+		Log.Warn(`telegram.real_ip_header is deprecated, use real_ip_header`)
+		config.RealIpHeader = config.Telegram.RealIpHeader
+	}
+	if config.Listen == `` {
+		config.Listen = `:8080`
+	}
+	if config.Telegram.Mode == `` {
+		config.Telegram.Mode = `webhook`
+	}
+	if config.Telegram.ApiUrl == `` {
+		config.Telegram.ApiUrl = telegram.DefaultApiUrl
+	}
+	config.Telegram.ApiUrl = strings.TrimSuffix(config.Telegram.ApiUrl, `/`)
+	if config.Telegram.SendInterval == 0 {
+		config.Telegram.SendInterval = 100 * time.Millisecond
+	}
+	if config.Telegram.IpAllowlist && len(config.Telegram.AllowedNetworks) == 0 {
+		config.Telegram.AllowedNetworks = TelegramNetworks
+	}
+	if config.Rss.Url == `` {
+		config.Rss.Url = DefaultFeedUrl
+	}
+	if config.Rss.MaxBodySize == 0 {
+		config.Rss.MaxBodySize = DefaultRssMaxBodySize
+	}
+	if config.Log.Level == `` {
+		config.Log.Level = `info`
+	}
+	if config.Log.Format == `` {
+		config.Log.Format = `text`
+	}
+	if config.Health.MaxIngestAge == 0 {
+		config.Health.MaxIngestAge = 2 * time.Hour
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 30 * time.Second
+	}
+	if config.BaseDir == `` {
+		config.BaseDir = `.`
+	}
+	if config.Rss.AuditLog == `` {
+		config.Rss.AuditLog = path.Join(config.BaseDir, `rss_audit.log`)
+	}
+	if config.ItemTemplate == `` {
+		config.ItemTemplate = DefaultItemTemplate
+	}
+}
+
+// ApplyEnv переопределяет значения из переменных окружения, чтобы, например, токен не хранить в файле.
+func (config *Config) ApplyEnv() error {
+	stringVars := map[string]*string{
+		`LISTEN`:                &config.Listen,
+		`TELEGRAM_TOKEN`:        &config.Telegram.Token,
+		`TELEGRAM_WEBHOOK`:      &config.Telegram.Webhook,
+		`TELEGRAM_MODE`:         &config.Telegram.Mode,
+		`TELEGRAM_API_URL`:      &config.Telegram.ApiUrl,
+		`TELEGRAM_SECRET_TOKEN`: &config.Telegram.SecretToken,
+		`RSS_URL`:               &config.Rss.Url,
+		`API_TOKEN`:             &config.Api.Token,
+		`DASHBOARD_USERNAME`:    &config.Dashboard.Username,
+		`DASHBOARD_PASSWORD`:    &config.Dashboard.Password,
+		`REAL_IP_HEADER`:        &config.RealIpHeader,
+		`BASE_DIR`:              &config.BaseDir,
+		`START_MESSAGE`:         &config.StartMessage,
+		`LOG_LEVEL`:             &config.Log.Level,
+		`LOG_FORMAT`:            &config.Log.Format,
+		`TRACING_EXPORTER`:      &config.Tracing.Exporter,
+		`TRACING_ENDPOINT`:      &config.Tracing.Endpoint,
+	}
+	for name, value := range stringVars {
+		if v, ok := os.LookupEnv(EnvPrefix + name); ok {
+			*value = v
+		}
+	}
+	boolVars := map[string]*bool{
+		`TELEGRAM_IP_ALLOWLIST`: &config.Telegram.IpAllowlist,
+		`ARTICLE_ENABLED`:       &config.Article.Enabled,
+		`TRACING_INSECURE`:      &config.Tracing.Insecure,
+	}
+	for name, value := range boolVars {
+		if v, ok := os.LookupEnv(EnvPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s%s: %s", EnvPrefix, name, err.Error())
+			}
+			*value = b
+		}
+	}
+	return nil
+}
+
+// Validate проверяет конфиг целиком и возвращает все найденные ошибки разом.
+func (config *Config) Validate() error {
+	problems := make([]string, 0)
+	if config.Telegram.Token == `` {
+		problems = append(problems, fmt.Sprintf("telegram.token is required (or %sTELEGRAM_TOKEN)", EnvPrefix))
+	}
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("listen: %s", err.Error()))
+	}
+	switch config.Telegram.Mode {
+	case `webhook`:
+		// Telegram шлет webhook'и только по https, а локальному Bot API server (telegram.api_url) хватает и http
+		schemes := map[string]bool{`https`: true}
+		if config.Telegram.ApiUrl != telegram.DefaultApiUrl {
+			schemes[`http`] = true
+		}
+		if u, err := url.Parse(config.Telegram.Webhook); err != nil || !schemes[u.Scheme] || u.Host == `` {
+			problems = append(problems,
+				`telegram.webhook must be an https URL in webhook mode (http only with a local telegram.api_url)`)
+		}
+	case `polling`:
+	default:
+		problems = append(problems, fmt.Sprintf("telegram.mode must be webhook or polling, got '%s'",
+			config.Telegram.Mode))
+	}
+	if u, err := url.Parse(config.Telegram.ApiUrl); err != nil || (u.Scheme != `http` && u.Scheme != `https`) ||
+		u.Host == `` {
+		problems = append(problems, fmt.Sprintf("telegram.api_url: invalid URL '%s'", config.Telegram.ApiUrl))
+	}
+	for _, network := range config.Telegram.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			problems = append(problems, fmt.Sprintf("telegram.allowed_networks: %s", err.Error()))
+		}
+	}
+	if u, err := url.Parse(config.Rss.Url); err != nil || u.Host == `` {
+		problems = append(problems, fmt.Sprintf("rss.url: invalid URL '%s'", config.Rss.Url))
+	}
+	if config.Telegram.SendInterval < 0 {
+		problems = append(problems, `telegram.send_interval must be positive`)
+	}
+	if config.Health.MaxIngestAge < 0 {
+		problems = append(problems, `health.max_ingest_age must be positive`)
+	}
+	if config.Rss.MaxBodySize < 0 {
+		problems = append(problems, `rss.max_body_size must be positive`)
+	}
+	if config.Dashboard.Password != `` && config.Dashboard.Username == `` {
+		problems = append(problems, `dashboard.username is required when dashboard.password is set`)
+	}
+	// Несуществующий base_dir создастся при старте, а вот файл на его месте - ошибка
+	if fileInfo, err := os.Stat(config.BaseDir); err != nil && !os.IsNotExist(err) {
+		problems = append(problems, fmt.Sprintf("base_dir: %s", err.Error()))
+	} else if err == nil && !fileInfo.IsDir() {
+		problems = append(problems, fmt.Sprintf("base_dir: %s is not a directory", config.BaseDir))
+	}
+	if _, err := template.New(`item`).Parse(config.ItemTemplate); err != nil {
+		problems = append(problems, fmt.Sprintf("item_template: %s", err.Error()))
+	}
+	if err := new(slog.LevelVar).UnmarshalText([]byte(config.Log.Level)); err != nil {
+		problems = append(problems, fmt.Sprintf("log.level: %s", err.Error()))
+	}
+	if config.Log.Format != `text` && config.Log.Format != `json` {
+		problems = append(problems, fmt.Sprintf("log.format must be text or json, got '%s'", config.Log.Format))
+	}
+	switch config.Tracing.Exporter {
+	case ``, `otlp`, `stdout`:
+	default:
+		problems = append(problems, fmt.Sprintf("tracing.exporter must be otlp or stdout, got '%s'",
+			config.Tracing.Exporter))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, `; `))
+	}
+	return nil
+}
+
+// RestartRequired возвращает параметры, изменение которых применится только после перезапуска.
+func (config *Config) RestartRequired(oldConfig *Config) []string {
+	changed := make([]string, 0)
+	if config.Listen != oldConfig.Listen {
+		changed = append(changed, `listen`)
+	}
+	if config.Telegram.Token != oldConfig.Telegram.Token {
+		changed = append(changed, `telegram.token`)
+	}
+	if config.Telegram.ApiUrl != oldConfig.Telegram.ApiUrl {
+		changed = append(changed, `telegram.api_url`)
+	}
+	if config.Telegram.Mode != oldConfig.Telegram.Mode {
+		changed = append(changed, `telegram.mode`)
+	}
+	if config.Log.Format != oldConfig.Log.Format {
+		changed = append(changed, `log.format`)
+	}
+	if config.Tracing != oldConfig.Tracing {
+		changed = append(changed, `tracing`)
+	}
+	if config.BaseDir != oldConfig.BaseDir {
+		changed = append(changed, `base_dir`)
+	}
+	return changed
+}
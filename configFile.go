@@ -3,16 +3,36 @@ package main
 import (
     "gopkg.in/yaml.v2"
     "os"
+    "os/signal"
     "sync"
+    "syscall"
     "time"
 )
 
 
+// ReloadSubscriber вызывается после успешной перезагрузки конфига со старой и новой версией.
+type ReloadSubscriber func(oldConfig, newConfig *Config)
+
 type ConfigFile struct {
     FilePath string
     FileModified time.Time
-    Config *Config
+    Config *Config // читать через Get()
     Mutex sync.Mutex
+    subscribers []ReloadSubscriber
+}
+
+// Get возвращает текущий конфиг. Config при перезагрузке заменяется целиком, а не меняется на месте, поэтому
+// полученный указатель можно использовать без блокировок.
+func (configFile *ConfigFile) Get() *Config {
+    configFile.Mutex.Lock()
+    defer configFile.Mutex.Unlock()
+    return configFile.Config
+}
+
+func (configFile *ConfigFile) OnReload(subscriber ReloadSubscriber) {
+    configFile.Mutex.Lock()
+    defer configFile.Mutex.Unlock()
+    configFile.subscribers = append(configFile.subscribers, subscriber)
 }
 
 func (configFile *ConfigFile) Save() error {
@@ -39,8 +59,20 @@ func (configFile *ConfigFile) Save() error {
 
 func (configFile *ConfigFile) Reload() error {
     configFile.Mutex.Lock()
-    defer configFile.Mutex.Unlock()
+    oldConfig := configFile.Config
+    err := configFile.reload()
+    newConfig := configFile.Config
+    subscribers := configFile.subscribers
+    configFile.Mutex.Unlock()
+
+    if err != nil || oldConfig == nil { return err }
+    for _, subscriber := range subscribers {
+        subscriber(oldConfig, newConfig)
+    }
+    return nil
+}
 
+func (configFile *ConfigFile) reload() error {
     f, err := os.Open(configFile.FilePath)
     if err != nil {
         ErrorLog.Println(configFile.FilePath, err.Error())
@@ -51,10 +83,20 @@ func (configFile *ConfigFile) Reload() error {
     config := Config{}
 
     decoder := yaml.NewDecoder(f)
+    decoder.SetStrict(true) // опечатка в ключе не должна молча превращаться в значение по умолчанию
     if err := decoder.Decode(&config); err != nil {
         ErrorLog.Println(configFile.FilePath, err.Error())
         return err
     }
+    if err := config.ApplyEnv(); err != nil {
+        ErrorLog.Println(err.Error())
+        return err
+    }
+    config.SetDefaults()
+    if err := config.Validate(); err != nil {
+        ErrorLog.Println(configFile.FilePath, err.Error())
+        return err
+    }
 
     configFile.Config = &config
     configFile.FileModified = configFile.GetFileMTime()
@@ -68,10 +110,21 @@ func (configFile *ConfigFile) GetFileMTime() time.Time {
 }
 
 func (configFile *ConfigFile) ReloadRoutine() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    ticker := time.NewTicker(30 * time.Second)
     for {
-        time.Sleep(30 * time.Second)
-        if configFile.GetFileMTime().After(configFile.FileModified) {
-            DebugLog.Printf("%s updated. Reloading config file...\n", configFile.FilePath)
+        select {
+        case <-ticker.C:
+            configFile.Mutex.Lock()
+            modified := configFile.FileModified
+            configFile.Mutex.Unlock()
+            if configFile.GetFileMTime().After(modified) {
+                DebugLog.Printf("%s updated. Reloading config file...\n", configFile.FilePath)
+                configFile.Reload()
+            }
+        case <-hup:
+            DebugLog.Printf("SIGHUP received. Reloading config file %s...\n", configFile.FilePath)
             configFile.Reload()
         }
     }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateWebhookScheme(t *testing.T) {
+	tests := []struct {
+		apiUrl  string
+		webhook string
+		valid   bool
+	}{
+		{``, `https://bot.example.com/`, true},
+		{``, `http://bot.example.com/`, false},
+		// Локальный Bot API server может слать webhook'и по http
+		{`http://127.0.0.1:8081`, `http://bot:8080/`, true},
+		{`http://127.0.0.1:8081`, `ftp://bot/`, false},
+		{`http://127.0.0.1:8081`, `http:///`, false},
+	}
+	for _, test := range tests {
+		config := Config{}
+		config.Telegram.Token = `test`
+		config.Telegram.Mode = `webhook`
+		config.Telegram.ApiUrl = test.apiUrl
+		config.Telegram.Webhook = test.webhook
+		config.SetDefaults()
+		if err := config.Validate(); (err == nil) != test.valid {
+			t.Errorf("api_url %q webhook %q: got %v, expected valid %v", test.apiUrl, test.webhook, err, test.valid)
+		}
+	}
+}
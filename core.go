@@ -1,44 +1,58 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/mmcdole/gofeed"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vvampirius/mygolibs/telegram"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Core struct {
 	ConfigFile  *ConfigFile
-	TelegramApi *telegram.Api
+	TelegramApi TelegramClient
 	State       *State
+	BotUsername string
+	Broadcasts  Broadcasts
+	History     *History
+	Catalog     *Catalog
+	Searches    Searches
+	Background  Background
+	StartedAt   time.Time
+	feedMutex   sync.Mutex // фиды обрабатываются по одному, иначе итемы разошлются дважды
+	// generatedSecretToken - secret_token для webhook'а, если он не задан в конфиге (см. WebhookSecretToken)
+	generatedSecretToken string
 }
 
-// GetNewItems возвращает список новых итемов относительно core.State.LastDate и последнее время побликации из них (если
-// новых нет - будет IsZero).
-func (core *Core) GetNewItems(items []*gofeed.Item) ([]*gofeed.Item, time.Time) {
-	lastDate := core.State.LastDate
-	var newLastDate time.Time
+// GetNewItems возвращает список итемов, которые еще не были полностью разосланы (см. State.IsNew).
+func (core *Core) GetNewItems(ctx context.Context, items []*gofeed.Item) []*gofeed.Item {
+	_, span := Tracer.Start(ctx, `GetNewItems`)
+	defer span.End()
 	newItems := make([]*gofeed.Item, 0)
 	for _, item := range items {
 		if item.PublishedParsed == nil {
-			ErrorLog.Println(`Can't parse`, item.PublishedParsed)
+			Log.Error(`Can't parse item date`, `item_guid`, item.GUID, `published`, item.Published)
 			PrometheusErrors.With(prometheus.Labels{`action`: `get_item_date`}).Inc()
 			continue
 		}
-		if item.PublishedParsed.After(lastDate) {
+		if core.State.IsNew(item.GUID, *item.PublishedParsed) {
 			newItems = append(newItems, item)
-			if item.PublishedParsed.After(newLastDate) {
-				newLastDate = *item.PublishedParsed
-			}
 		}
 	}
-	return newItems, newLastDate
+	span.SetAttributes(attribute.Int(`items`, len(items)), attribute.Int(`new_items`, len(newItems)))
+	return newItems
 }
 
 func (core *Core) ReverseItems(items []*gofeed.Item) []*gofeed.Item {
@@ -54,55 +68,148 @@ func (core *Core) ReverseItems(items []*gofeed.Item) []*gofeed.Item {
 }
 
 func (core *Core) RssHttpHandler(w http.ResponseWriter, r *http.Request) {
-	DebugLog.Println(r.Method, r.RequestURI, r.UserAgent())
+	ctx, span := Tracer.Start(r.Context(), `RssHttpHandler`, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	logger := requestLogger(w, r).With(`handler`, `rss`)
+	logger.Debug(`Request`, `method`, r.Method, `uri`, r.RequestURI, `ip`, core.RemoteIp(r).String(),
+		`user_agent`, r.UserAgent())
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, core.ConfigFile.Get().Rss.MaxBodySize))
+	if err != nil {
+		logger.Error(`Can't read body`, `error`, err.Error())
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			PrometheusRejectedRequests.With(prometheus.Labels{`handler`: `rss`, `reason`: `body_size`}).Inc()
+			core.AuditRss(r, ``, fmt.Sprintf("rejected: body larger than %d bytes", maxBytesError.Limit))
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client, err := core.AuthenticateRss(r, body)
+	if err != nil {
+		logger.Warn(`Unauthorized`, `ip`, core.RemoteIp(r).String(), `client`, client, `error`, err.Error())
+		PrometheusRejectedRequests.With(prometheus.Labels{`handler`: `rss`, `reason`: `auth`}).Inc()
+		core.AuditRss(r, client, `rejected: `+err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 	parser := gofeed.NewParser()
-	feed, err := parser.Parse(r.Body)
+	feed, err := parser.Parse(bytes.NewReader(body))
 	if err != nil {
-		ErrorLog.Println(err.Error())
+		logger.Error(`Can't parse feed`, `client`, client, `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `parse_rss`}).Inc()
+		core.AuditRss(r, client, `rejected: `+err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	go func() {
-		DebugLog.Println(`Last date:`, core.State.LastDate.Format("02.01 15:04:05 MST"))
-		items, newLastDate := core.GetNewItems(feed.Items)
-		PrometheusNewItems.Add(float64(len(items)))
-		for _, item := range core.ReverseItems(items) {
-			categories := diveIntoCategories(item.Categories)
-			DebugLog.Printf("%s / %v %s %s\n", item.PublishedParsed.Format("02.01 15:04:05 MST"), categories, item.Title, item.Link)
-			core.State.AddCategory(categories...)
-			core.SendItem(item.Title, item.Link, categories)
+	logger = logger.With(`client`, client, `feed`, feed.Link)
+	span.SetAttributes(attribute.String(`rss.client`, client), attribute.Int(`rss.items`, len(feed.Items)))
+	// Рассылка переживает запрос, поэтому от его контекста берем только спан
+	ctx = trace.ContextWithSpan(context.Background(), span)
+	started := core.Background.Go(func() {
+		items := core.ProcessFeed(ctx, feed, logger)
+		core.AuditRss(r, client, auditItems(len(feed.Items), items))
+	})
+	if !started {
+		logger.Warn(`Shutting down, feed rejected`)
+		core.AuditRss(r, client, `rejected: shutting down`)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// ProcessFeed рассылает новые итемы фида и возвращает их. Итемы рассылаются от старых к новым, и после каждого
+// LastDate сдвигается на него, а внутри рассылки сохраняется checkpoint - так после падения ничего не теряется и
+// никому не приходит повторно.
+func (core *Core) ProcessFeed(ctx context.Context, feed *gofeed.Feed, logger *slog.Logger) []*gofeed.Item {
+	ctx, span := Tracer.Start(ctx, `ProcessFeed`)
+	defer span.End()
+	core.feedMutex.Lock()
+	defer core.feedMutex.Unlock()
+	items := core.GetNewItems(ctx, feed.Items)
+	logger.Info(`Feed received`, `items`, len(feed.Items), `new_items`, len(items), `last_date`, core.State.GetLastDate())
+	PrometheusNewItems.Add(float64(len(items)))
+	for _, item := range core.ReverseItems(items) {
+		itemLogger := logger.With(`item_guid`, item.GUID)
+		// Начатый итем досылаем, а новые не берем: LastDate на них не сдвинут, они придут со следующим фидом
+		if core.Background.IsStopping() {
+			itemLogger.Info(`Shutting down, item postponed`)
+			break
 		}
-		if newLastDate.After(core.State.LastDate) {
-			core.State.LastDate = newLastDate
-			if err := core.State.Save(); err != nil {
-				ErrorLog.Println(err.Error())
+		categories := diveIntoCategories(item.Categories)
+		itemLogger.Info(`New item`, `published`, *item.PublishedParsed, `categories`, categories, `title`, item.Title,
+			`link`, item.Link)
+		core.State.AddCategory(categories...)
+		historyItem := HistoryItem{
+			Guid:        item.GUID,
+			Title:       item.Title,
+			Link:        item.Link,
+			Categories:  categories,
+			Description: stripHtml(item.Description),
+			Published:   *item.PublishedParsed,
+		}
+		resumed := false
+		if checkpoint := core.State.GetCheckpoint(item.GUID); checkpoint != nil {
+			if existing, ok := core.History.Get(checkpoint.HistoryId); ok && existing.Guid == item.GUID {
+				itemLogger.Info(`Resuming delivery`, `delivered`, len(checkpoint.Delivered))
+				historyItem = existing
+				resumed = true
 			}
 		}
-	}()
+		if !resumed {
+			historyItem.Id, _ = core.History.Add(historyItem)
+			if err := core.State.StartItem(item.GUID, *item.PublishedParsed, historyItem.Id); err != nil {
+				itemLogger.Error(`Can't save checkpoint`, `error`, err.Error())
+			}
+		}
+		if core.ConfigFile.Get().Article.Enabled {
+			core.GetArticle(historyItem)
+		}
+		delivery := core.SendItem(ctx, historyItem, itemLogger)
+		itemLogger.Info(`Item delivered`, `sent`, delivery.Sent, `skipped`, delivery.Skipped, `failed`, delivery.Failed)
+		PrometheusDeliveryLatency.Observe(time.Since(*item.PublishedParsed).Seconds())
+		core.History.SetDelivery(historyItem.Id, delivery)
+		if err := core.State.CompleteItem(item.GUID, *item.PublishedParsed); err != nil {
+			itemLogger.Error(`Can't save state`, `error`, err.Error())
+		}
+	}
+	core.State.SetLastIngestAt(time.Now())
+	if err := core.State.Save(); err != nil {
+		logger.Error(`Can't save state`, `error`, err.Error())
+	}
+	return items
+}
+
+func (core *Core) UserPath(id int) string {
+	return path.Join(core.ConfigFile.Get().BaseDir, `users`, fmt.Sprintf("%d.yml", id))
 }
 
 func (core *Core) GetUser(id int) (*User, error) {
-	user, err := NewUser(path.Join(core.ConfigFile.Config.BaseDir, `users`, fmt.Sprintf("%d.yml", id)))
+	user, err := NewUser(core.UserPath(id))
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (core *Core) GetOrCreateUser(info telegram.User) (*User, error) {
-	user, err := core.GetUser(info.Id)
+// GetOrCreateChat возвращает подписку чата, создавая её при необходимости. from - кто её создает (для личных чатов -
+// сам пользователь).
+func (core *Core) GetOrCreateChat(chat telegram.Chat, from telegram.User) (*User, error) {
+	user, err := core.GetUser(chat.Id)
 	if err != nil {
 		return nil, err
 	}
 	if user.Id() == 0 {
-		user.Info = info
+		user.Info = from
 		user.CreatedAt = time.Now()
+		user.Language = core.Catalog.Detect(from.LanguageCode)
 	}
+	user.Chat = chat
+	user.Blocked = false // раз нам пишут из этого чата - бот не заблокирован
 	if err := user.Save(); err != nil {
 		return nil, err
 	}
@@ -110,9 +217,9 @@ func (core *Core) GetOrCreateUser(info telegram.User) (*User, error) {
 }
 
 func (core *Core) GetUsers() ([]*User, error) {
-	items, err := os.ReadDir(path.Join(core.ConfigFile.Config.BaseDir, `users`))
+	items, err := os.ReadDir(path.Join(core.ConfigFile.Get().BaseDir, `users`))
 	if err != nil {
-		ErrorLog.Println(err.Error())
+		Log.Error(`Can't read users`, `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `get_users`}).Inc()
 		return nil, err
 	}
@@ -121,7 +228,7 @@ func (core *Core) GetUsers() ([]*User, error) {
 		if item.IsDir() {
 			continue
 		}
-		user, err := NewUser(path.Join(core.ConfigFile.Config.BaseDir, `users`, item.Name()))
+		user, err := NewUser(path.Join(core.ConfigFile.Get().BaseDir, `users`, item.Name()))
 		if err != nil {
 			continue
 		}
@@ -143,74 +250,191 @@ func (core *Core) CategoriesToTagsString(categories []string) string {
 	return s
 }
 
-func (core *Core) SendItem(content, url string, categories []string) {
+// SendItem рассылает итем подписчикам и возвращает, сколько кому доставлено.
+func (core *Core) SendItem(ctx context.Context, item HistoryItem, logger *slog.Logger) Delivery {
+	ctx, span := Tracer.Start(ctx, `SendItem`, trace.WithAttributes(attributeItemGuid.String(item.Guid)))
+	defer span.End()
+	delivery := Delivery{}
 	users, err := core.GetUsers()
 	if err != nil {
-		return
+		return delivery
 	}
+	// Текст у всех одинаковый: шаблон и статья не должны разбираться заново для каждого подписчика
+	text := core.ItemText(item)
 	for _, user := range users {
-		if user.IsInExcludedCategories(categories...) {
-			DebugLog.Printf("skip for %s\n", user.Name())
+		if !user.IsActive() {
+			continue
+		}
+		userLogger := logger.With(`user_id`, user.Id())
+		if user.IsInExcludedCategories(item.Categories...) {
+			userLogger.Debug(`Category excluded, skip`)
+			span.AddEvent(`skip`, trace.WithAttributes(attributeUserId.Int(user.Id()),
+				attribute.String(`reason`, `excluded_category`)))
+			delivery.Skipped++
+			continue
+		}
+		if core.State.IsDelivered(user.Id()) {
+			userLogger.Debug(`Already sent`)
+			span.AddEvent(`skip`, trace.WithAttributes(attributeUserId.Int(user.Id()),
+				attribute.String(`reason`, `already_sent`)))
 			continue
 		}
-		DebugLog.Printf("send to %s\n", user.Name())
-		PrometheusSendItems.With(prometheus.Labels{`username`: user.Name()}).Inc()
-		msgText := url
-		if tags := core.CategoriesToTagsString(categories); tags != `` {
-			msgText = tags + "\n\n" + msgText
+		err := core.Deliver(ctx, user, text, core.ItemMarkup(item, user.Lang()))
+		// Отмечаем и неудачные попытки: по ошибке не понять, дошло ли сообщение, а дубль хуже пропуска
+		if err := core.State.MarkDelivered(user.Id()); err != nil {
+			userLogger.Error(`Can't save checkpoint`, `error`, err.Error())
 		}
-		message := telegram.SendMessageIntWithoutReplyMarkup{}
-		message.ChatId = user.Id()
-		message.Text = msgText
-		if err := core.TelegramApi.RequestWrapper(``, message, func() { core.RemoveUser(user.Id()) }); err != nil {
-			PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+		core.SendPause()
+		if err != nil {
+			userLogger.Warn(`Delivery failed`, `error`, err.Error())
+			PrometheusSendItems.With(prometheus.Labels{`result`: `failed`}).Inc()
+			delivery.Failed++
+			continue
 		}
-		time.Sleep(100 * time.Millisecond)
+		userLogger.Debug(`Sent`)
+		PrometheusSendItems.With(prometheus.Labels{`result`: `sent`}).Inc()
+		delivery.Sent++
+		core.State.AddDelivered(1)
 	}
+	span.SetAttributes(attribute.Int(`delivery.sent`, delivery.Sent), attribute.Int(`delivery.skipped`, delivery.Skipped),
+		attribute.Int(`delivery.failed`, delivery.Failed))
+	return delivery
+}
+
+// ItemMarkup - кнопки под сообщением с итемом на языке language.
+func (core *Core) ItemMarkup(item HistoryItem, language string) *telegram.InlineKeyboardMarkup {
+	buttons := []telegram.InlineKeyboardButton{
+		{Text: core.Catalog.Text(language, `button_save`), CallbackData: fmt.Sprintf("save|%d", item.Id)},
+	}
+	if core.ConfigFile.Get().Article.Enabled {
+		buttons = append(buttons, telegram.InlineKeyboardButton{
+			Text:         core.Catalog.Text(language, `button_read`),
+			CallbackData: fmt.Sprintf("read|%d", item.Id),
+		})
+	}
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{buttons},
+	}
+}
+
+// ErrDeactivated - подписка отключена админом через API.
+var ErrDeactivated = errors.New(`subscription deactivated`)
+
+// Deliver отправляет сообщение подписчику. Используется для всех массовых рассылок: если бот заблокирован - подписка
+// деактивируется. Паузу между отправками выдерживает вызывающий через SendPause - уже после того, как отметил отправку.
+func (core *Core) Deliver(ctx context.Context, user *User, text string, markup *telegram.InlineKeyboardMarkup) error {
+	if user.Deactivated {
+		return ErrDeactivated
+	}
+	_, span := Tracer.Start(ctx, `sendMessage`, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributeUserId.Int(user.Id())))
+	message := telegram.SendMessageIntWithoutReplyMarkup{}
+	message.ChatId = user.Id()
+	message.Text = text
+	var payload interface{} = message
+	if markup != nil {
+		payload = telegram.SendMessageIntWithInlineKeyboardMarkup{
+			SendMessageIntWithoutReplyMarkup: message,
+			ReplyMarkup:                      *markup,
+		}
+	}
+	started := time.Now()
+	err := core.TelegramApi.RequestWrapper(``, payload, func() { core.SetUserBlocked(user.Id(), true) })
+	PrometheusSendDuration.Observe(time.Since(started).Seconds())
+	endSpan(span, err)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+	return err
+}
+
+// SendPause - пауза между сообщениями рассылки, чтобы не упереться в лимиты Telegram.
+func (core *Core) SendPause() {
+	time.Sleep(core.ConfigFile.Get().Telegram.SendInterval)
 }
 
 func (core *Core) TelegramHttpHandler(w http.ResponseWriter, r *http.Request) {
-	DebugLog.Printf("%s : %s : %s : %s\n", r.Header.Get(`X-Real-IP`), r.Method, r.RequestURI, r.UserAgent())
+	logger := requestLogger(w, r).With(`handler`, `telegram`)
+	logger.Debug(`Request`, `method`, r.Method, `uri`, r.RequestURI, `ip`, core.RemoteIp(r).String(),
+		`user_agent`, r.UserAgent())
 	if r.Method != http.MethodPost {
-		ErrorLog.Println(r.Method, r.Header, r.RequestURI)
+		logger.Warn(`Bad method`, `method`, r.Method, `uri`, r.RequestURI)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := core.VerifyTelegramRequest(r); err != nil {
+		logger.Warn(`Rejected`, `ip`, core.RemoteIp(r).String(), `error`, err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		ErrorLog.Println(err.Error())
+		logger.Error(`Can't read body`, `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_handler`}).Inc()
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	update, err := telegram.UnmarshalUpdate(body)
+	update, err := UnmarshalUpdate(body)
 	if err != nil {
-		ErrorLog.Println(string(body), err.Error())
+		logger.Error(`Can't parse update`, `body`, string(body), `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_handler`}).Inc()
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	go func() {
-		if update.IsMessage() {
-			core.TelegramMessage(update)
-			return
-		}
-		if update.IsCallbackQuery() {
-			core.TelegramCallback(update)
-			return
-		}
-	}()
+	// Telegram повторит апдейт, на который не ответили 200
+	if !core.Background.Go(func() { core.DispatchUpdate(update, logger) }) {
+		logger.Warn(`Shutting down, update rejected`, `update_id`, update.Id)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// DispatchUpdate - общая точка входа для апдейтов из webhook'а и из polling'а.
+func (core *Core) DispatchUpdate(update Update, logger *slog.Logger) {
+	logger = logger.With(`update_id`, update.Id)
+	if update.IsMessage() && update.Message.MigrateToChatId != 0 {
+		logger.Info(`Chat migrated`, `chat_id`, update.Message.Chat.Id, `new_chat_id`, update.Message.MigrateToChatId)
+		core.MigrateChat(update.Message.Chat.Id, update.Message.MigrateToChatId)
+		return
+	}
+	if update.IsMessage() {
+		logger.Debug(`Message`, `chat_id`, update.Message.Chat.Id, `user_id`, update.Message.From.Id,
+			`text`, update.Message.Text)
+		core.TelegramMessage(update.Message.Message)
+		return
+	}
+	if update.IsChannelPost() {
+		logger.Debug(`Channel post`, `chat_id`, update.ChannelPost.Chat.Id, `text`, update.ChannelPost.Text)
+		core.TelegramMessage(update.ChannelPost)
+		return
+	}
+	if update.IsCallbackQuery() {
+		logger.Debug(`Callback query`, `chat_id`, update.CallbackQuery.Message.Chat.Id,
+			`user_id`, update.CallbackQuery.From.Id, `data`, update.CallbackQuery.Data)
+		core.TelegramCallback(update)
+		return
+	}
+	if update.IsMyChatMember() {
+		logger.Info(`My chat member`, `chat_id`, update.MyChatMember.Chat.Id, `user_id`, update.MyChatMember.From.Id,
+			`status`, update.MyChatMember.NewChatMember.Status)
+		core.TelegramMyChatMember(update.MyChatMember)
+		return
+	}
+	if update.IsInlineQuery() {
+		logger.Debug(`Inline query`, `user_id`, update.InlineQuery.From.Id, `query`, update.InlineQuery.Query)
+		core.TelegramInlineQuery(update.InlineQuery)
+		return
+	}
+	logger.Debug(`Unsupported update`)
 }
 
 func (core *Core) RemoveUser(id int) error {
-	DebugLog.Printf("Removing user %d", id)
-	return os.Remove(path.Join(core.ConfigFile.Config.BaseDir, `users`,
-		fmt.Sprintf("%d.yml", id)))
+	Log.Info(`Removing user`, `user_id`, id)
+	return os.Remove(core.UserPath(id))
 }
 
 func (core *Core) GetCategoriesButtons(user *User) [][]telegram.InlineKeyboardButton {
 	buttons := make([][]telegram.InlineKeyboardButton, 0)
-	for _, category := range core.State.Categories {
+	for _, category := range core.State.GetCategories() {
 		if user.IsInExcludedCategories(category) {
 			buttons = append(buttons, []telegram.InlineKeyboardButton{{
 				Text:         fmt.Sprintf("⛔️%s", category),
@@ -226,76 +450,189 @@ func (core *Core) GetCategoriesButtons(user *User) [][]telegram.InlineKeyboardBu
 	return buttons
 }
 
-func (core *Core) TelegramMessage(update telegram.Update) {
-	DebugLog.Println(update.Message.From, update.Message.Text)
-	switch update.Message.Text {
+func (core *Core) TelegramMessage(message telegram.Message) {
+	command, _ := parseCommand(message.Text, core.BotUsername)
+	switch command {
 	case `/start`:
 		core.TelegramApi.RequestWrapper(`deleteMessage`, telegram.DeleteMessageInt{
-			ChatId:    update.Message.Chat.Id,
-			MessageId: update.Message.Id,
+			ChatId:    message.Chat.Id,
+			MessageId: message.Id,
 		}, nil)
-		message := telegram.SendMessageIntWithoutReplyMarkup{}
-		message.ChatId = update.Message.From.Id
-		message.Text = core.ConfigFile.Config.StartMessage
-		if _, err := core.GetOrCreateUser(update.Message.From); err != nil {
-			message.Text = fmt.Sprintf("%s\n\nОшибка: %s", message.Text, err.Error())
+		reply := telegram.SendMessageIntWithoutReplyMarkup{}
+		reply.ChatId = message.Chat.Id
+		reply.Text = core.ConfigFile.Get().StartMessage
+		isNew := false
+		if existing, err := core.GetUser(message.Chat.Id); err == nil && existing.Id() == 0 {
+			isNew = true
+		}
+		user, err := core.GetOrCreateChat(message.Chat, message.From)
+		if err != nil {
+			reply.Text = core.Catalog.Text(core.Catalog.Detect(message.From.LanguageCode), `error_details`, reply.Text,
+				err.Error())
+		} else if user.Language == `` {
+			// Подписка создана до появления переводов
+			if err := user.SetLanguage(core.Catalog.Detect(message.From.LanguageCode)); err != nil {
+				PrometheusErrors.With(prometheus.Labels{`action`: `save`}).Inc()
+			}
 		}
-		if message.Text != `` {
-			if err := core.TelegramApi.RequestWrapper(``, message, nil); err != nil {
+		if reply.Text != `` {
+			if err := core.TelegramApi.RequestWrapper(``, reply, nil); err != nil {
 				PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
 			}
 		}
+		if err == nil && isNew {
+			core.SendLastItems(user, WelcomeItems)
+		}
 	case `/categories`:
-		user, err := core.GetOrCreateUser(update.Message.From)
+		language := core.ChatLanguage(message.Chat.Id, message.From)
+		if !core.IsChatAdmin(message.Chat, message.From.Id) {
+			Log.Debug(`Not an admin`, `user_id`, message.From.Id, `chat_id`, message.Chat.Id)
+			core.SendText(message.Chat.Id, core.Catalog.Text(language, `admins_only`))
+			return
+		}
+		user, err := core.GetOrCreateChat(message.Chat, message.From)
 		if err != nil {
 			PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
-			message := telegram.SendMessageIntWithoutReplyMarkup{}
-			message.ChatId = update.Message.From.Id
-			message.Text = `Извините, произошла ошибка.`
-			if err := core.TelegramApi.RequestWrapper(``, message, nil); err != nil {
-				PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
-			}
+			core.SendText(message.Chat.Id, core.Catalog.Text(language, `error`))
+			return
 		}
 		core.TelegramApi.RequestWrapper(`deleteMessage`, telegram.DeleteMessageInt{
-			ChatId:    update.Message.Chat.Id,
-			MessageId: update.Message.Id,
+			ChatId:    message.Chat.Id,
+			MessageId: message.Id,
 		}, nil)
-		payload := telegram.SendMessageIntWithInlineKeyboardMarkup{
-			ReplyMarkup: telegram.InlineKeyboardMarkup{
-				InlineKeyboard: core.GetCategoriesButtons(user),
-			},
-		}
-		payload.Text = `Категории:`
-		payload.ChatId = update.Message.From.Id
-		if err := core.TelegramApi.RequestWrapper(``, payload, nil); err != nil {
-			PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
-		}
+		core.SendCategories(user.Id(), user)
+	case `/language`:
+		core.TelegramLanguage(message)
+	case `/last`:
+		core.TelegramLast(message)
+	case `/search`:
+		core.TelegramSearch(message)
+	case `/saved`:
+		core.TelegramSaved(message)
+	case `/broadcast`:
+		core.TelegramBroadcast(message)
+	case `/stats`:
+		core.TelegramStats(message)
+	}
+}
+
+func (core *Core) SendText(chatId int, text string) {
+	message := telegram.SendMessageIntWithoutReplyMarkup{}
+	message.ChatId = chatId
+	message.Text = text
+	if err := core.TelegramApi.RequestWrapper(``, message, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
+
+func (core *Core) SendCategories(chatId int, user *User) {
+	payload := telegram.SendMessageIntWithInlineKeyboardMarkup{
+		ReplyMarkup: telegram.InlineKeyboardMarkup{
+			InlineKeyboard: core.GetCategoriesButtons(user),
+		},
+	}
+	payload.Text = core.Catalog.Text(user.Lang(), `categories`)
+	payload.ChatId = chatId
+	if err := core.TelegramApi.RequestWrapper(``, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
+
+// AnswerCallback убирает "часики" на кнопке у пользователя. Если alert - текст показывается модальным окном.
+func (core *Core) AnswerCallback(id, text string, alert bool) {
+	payload := AnswerCallbackQuery{
+		CallbackQueryId: id,
+		Text:            text,
+		ShowAlert:       alert,
+	}
+	if err := core.TelegramApi.RequestWrapper(`answerCallbackQuery`, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
 	}
 }
 
-func (core *Core) TelegramCallback(update telegram.Update) {
-	user, err := core.GetUser(update.CallbackQuery.Message.Chat.Id)
+func (core *Core) TelegramCallback(update Update) {
+	callbackId := update.CallbackQuery.Id
+	chat := update.CallbackQuery.Message.Chat
+	language := core.ChatLanguage(chat.Id, update.CallbackQuery.From)
+	command := strings.SplitN(update.CallbackQuery.Data, `|`, 2)
+	if len(command) != 2 {
+		Log.Error(`Unknown callback data`, `data`, update.CallbackQuery.Data, `user_id`, update.CallbackQuery.From.Id)
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `unknown_command`), true)
+		return
+	}
+	// Листать результаты поиска и сохранять закладки может любой участник чата
+	switch command[0] {
+	case `search`:
+		core.SearchCallback(update, command[1])
+		return
+	case `save`, `unsave`, `saved`:
+		core.BookmarkCallback(update, command[0], command[1])
+		return
+	case `read`:
+		core.ReadCallback(update, command[1])
+		return
+	}
+	if !core.IsChatAdmin(chat, update.CallbackQuery.From.Id) {
+		Log.Debug(`Not an admin`, `user_id`, update.CallbackQuery.From.Id, `chat_id`, chat.Id)
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `admins_only`), true)
+		return
+	}
+	user, err := core.GetOrCreateChat(chat, update.CallbackQuery.From)
 	if err != nil {
-		ErrorLog.Println(err.Error())
+		Log.Error(`Can't get user`, `chat_id`, chat.Id, `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `error`), true)
 		return
 	}
-	command := strings.Split(update.CallbackQuery.Data, `|`)
 	switch command[0] {
 	case `include`:
-		DebugLog.Printf("%s want to include: %s\n", user.Name(), command[1])
+		Log.Debug(`Include category`, `user_id`, user.Id(), `category`, command[1])
 		if err := user.RemoveExcludedCategory(command[1]); err != nil {
-			ErrorLog.Println(err.Error())
+			Log.Error(`Can't include category`, `user_id`, user.Id(), `category`, command[1], `error`, err.Error())
 			PrometheusErrors.With(prometheus.Labels{`action`: `include`}).Inc()
+			if errors.Is(err, ErrNotExcluded) {
+				core.AnswerCallback(callbackId, core.Catalog.Text(language, `category_already_included`, command[1]), true)
+			} else {
+				core.AnswerCallback(callbackId, core.Catalog.Text(language, `settings_save_failed`), true)
+			}
+			core.EditCategories(update, user)
 			return
 		}
+		core.AnswerCallback(callbackId, fmt.Sprintf("🔶 %s", command[1]), false)
+	case `broadcast`:
+		core.BroadcastCallback(update, user, command[1])
+		return
+	case `language`:
+		core.LanguageCallback(update, user, command[1])
+		return
 	case `exclude`:
-		DebugLog.Printf("%s want to exclude: %s\n", user.Name(), command[1])
+		Log.Debug(`Exclude category`, `user_id`, user.Id(), `category`, command[1])
 		if err := user.AddExcludedCategory(command[1]); err != nil {
-			ErrorLog.Println(err.Error())
+			Log.Error(`Can't exclude category`, `user_id`, user.Id(), `category`, command[1], `error`, err.Error())
 			PrometheusErrors.With(prometheus.Labels{`action`: `exclude`}).Inc()
+			if errors.Is(err, ErrAlreadyExcluded) {
+				core.AnswerCallback(callbackId, core.Catalog.Text(language, `category_already_excluded`, command[1]), true)
+			} else {
+				core.AnswerCallback(callbackId, core.Catalog.Text(language, `settings_save_failed`), true)
+			}
+			core.EditCategories(update, user)
 			return
 		}
+		core.AnswerCallback(callbackId, fmt.Sprintf("⛔️%s", command[1]), false)
+	default:
+		Log.Error(`Unknown callback command`, `command`, command[0], `user_id`, user.Id())
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `unknown_command`), true)
+		return
+	}
+	core.EditCategories(update, user)
+}
+
+// EditCategories обновляет кнопки в сообщении, из которого пришел callback. Если сообщение слишком старое и Telegram
+// не дает его редактировать (или прислал его как недоступное, с нулевой датой) - присылаем новое.
+func (core *Core) EditCategories(update Update, user *User) {
+	if update.CallbackQuery.Message.Date == 0 {
+		core.SendCategories(update.CallbackQuery.Message.Chat.Id, user)
+		return
 	}
 	payload := telegram.EditMessageIntInlineKeyboardMarkup{
 		ChatId:    update.CallbackQuery.Message.Chat.Id,
@@ -304,20 +641,64 @@ func (core *Core) TelegramCallback(update telegram.Update) {
 			InlineKeyboard: core.GetCategoriesButtons(user),
 		},
 	}
-	if err := core.TelegramApi.RequestWrapper(`editMessageReplyMarkup`, payload, nil); err != nil {
+	err := core.TelegramApi.RequestWrapper(`editMessageReplyMarkup`, payload, nil)
+	switch {
+	case err == nil, isMessageNotModified(err):
+	case isMessageNotEditable(err):
+		Log.Debug(`Message is too old to edit, sending new one`, `message_id`, payload.MessageId, `user_id`, user.Id())
+		core.SendCategories(payload.ChatId, user)
+	default:
 		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
 	}
 }
 
-func NewCore(configFile *ConfigFile, telegramApi *telegram.Api, state *State) (*Core, error) {
+func NewCore(configFile *ConfigFile, telegramApi TelegramClient, state *State, history *History, catalog *Catalog,
+	botUsername string) (*Core, error) {
 	core := Core{
 		ConfigFile:  configFile,
 		TelegramApi: telegramApi,
 		State:       state,
+		History:     history,
+		Catalog:     catalog,
+		BotUsername: botUsername,
+		StartedAt:   time.Now(),
 	}
-	if err := os.MkdirAll(path.Join(configFile.Config.BaseDir, `users`), 0744); err != nil {
+	core.generatedSecretToken = newSecretToken()
+	if err := os.MkdirAll(path.Join(configFile.Get().BaseDir, `users`), 0744); err != nil {
 		ErrorLog.Println(err.Error())
 		return nil, err
 	}
+	if err := os.MkdirAll(path.Join(configFile.Get().BaseDir, `articles`), 0744); err != nil {
+		ErrorLog.Println(err.Error())
+		return nil, err
+	}
+	history.OnEvict(core.RemoveArticle)
+	configFile.OnReload(core.ConfigReloaded)
 	return &core, nil
 }
+
+// ConfigReloaded применяет изменения конфига на лету. Остальное (стартовое сообщение, фид, шаблон, интервал
+// рассылки, токены API и админки) и так читается из ConfigFile при каждом использовании.
+func (core *Core) ConfigReloaded(oldConfig, newConfig *Config) {
+	if changed := newConfig.RestartRequired(oldConfig); len(changed) > 0 {
+		Log.Warn(`Config changes require restart to apply`, `changed`, changed)
+	}
+	if err := LogLevel.UnmarshalText([]byte(newConfig.Log.Level)); err != nil {
+		ErrorLog.Println(err.Error())
+	}
+	WarnRssUnauthenticated(newConfig)
+	// Переводы из base_dir перечитываем вместе с конфигом (по SIGHUP)
+	if err := core.Catalog.Load(newConfig.BaseDir); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `load`}).Inc()
+	}
+	webhookChanged := newConfig.Telegram.Webhook != oldConfig.Telegram.Webhook ||
+		newConfig.Telegram.SecretToken != oldConfig.Telegram.SecretToken
+	if newConfig.Telegram.Mode == `webhook` && oldConfig.Telegram.Mode == `webhook` && webhookChanged {
+		if err := core.SetWebhook(); err != nil {
+			Log.Error(`Can't apply new webhook`, `error`, err.Error())
+			return
+		}
+		Log.Info(`Callback URL changed`, `webhook`, newConfig.Telegram.Webhook)
+	}
+	Log.Info(`Config reloaded`)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"github.com/prometheus/client_golang/prometheus"
+	"html/template"
+	"net/http"
+	"sort"
+)
+
+//go:embed web/*.html
+var webFiles embed.FS
+
+var dashboardTemplate = template.Must(template.New(`dashboard.html`).Funcs(template.FuncMap{
+	`date`: formatStatsTime,
+}).ParseFS(webFiles, `web/dashboard.html`))
+
+// DashboardItems - сколько последних итемов показывать.
+const DashboardItems = 50
+
+type DashboardData struct {
+	Stats       Stats
+	Users       []*User
+	RecentItems []HistoryItem
+	Categories  []string
+}
+
+func (core *Core) VerifyDashboardRequest(r *http.Request) bool {
+	config := core.ConfigFile.Get().Dashboard
+	if config.Password == `` {
+		return false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	usernameOk := subtle.ConstantTimeCompare([]byte(username), []byte(config.Username)) == 1
+	passwordOk := subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) == 1
+	return usernameOk && passwordOk
+}
+
+// DashboardHttpHandler - веб-интерфейс для поддержки пользователей (только просмотр).
+func (core *Core) DashboardHttpHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(w, r).With(`handler`, `dashboard`)
+	logger.Debug(`Request`, `method`, r.Method, `uri`, r.RequestURI, `ip`, core.RemoteIp(r).String(),
+		`user_agent`, r.UserAgent())
+	if !core.VerifyDashboardRequest(r) {
+		PrometheusRejectedRequests.With(prometheus.Labels{`handler`: `dashboard`, `reason`: `auth`}).Inc()
+		w.Header().Set(`WWW-Authenticate`, `Basic realm="onliner-auto-bot"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := core.GetStats()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	users, err := core.GetUsers()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.After(users[j].CreatedAt) })
+	data := DashboardData{
+		Stats:       stats,
+		Users:       users,
+		RecentItems: core.History.Last(DashboardItems, nil),
+		Categories:  core.State.GetCategories(),
+	}
+	w.Header().Set(`Content-Type`, `text/html; charset=utf-8`)
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		logger.Error(`Can't render dashboard`, `error`, err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vvampirius/mygolibs/telegram"
+	"sync"
+	"time"
+)
+
+// FakeTelegramRequest - запрос, который ушел бы в Telegram.
+type FakeTelegramRequest struct {
+	Method  string
+	Payload json.RawMessage
+}
+
+// FakeTelegram - TelegramClient без сети для тестов: запоминает запросы и отвечает успехом.
+type FakeTelegram struct {
+	mutex    sync.Mutex
+	Me       telegram.Me
+	requests []FakeTelegramRequest
+	// Blocked - чаты, которые "заблокировали бота": отправка в них вызывает onBlocked и возвращает ошибку
+	Blocked map[int]bool
+	// Responses - готовые ответы DoWithRetry по методам (например getChatMember). По умолчанию пустой успех.
+	Responses map[string][]byte
+	// Updates отдаются в getUpdates
+	Updates chan Update
+}
+
+func (fake *FakeTelegram) record(method string, payload []byte) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.requests = append(fake.requests, FakeTelegramRequest{Method: method, Payload: payload})
+}
+
+func (fake *FakeTelegram) RequestWrapper(method string, payload interface{}, onBlocked func()) error {
+	if method == `` {
+		method = `sendMessage`
+	}
+	data, err := telegram.JsonEncode(payload)
+	if err != nil {
+		return err
+	}
+	fake.record(method, data)
+	var chat struct {
+		ChatId int `json:"chat_id"`
+	}
+	json.Unmarshal(data, &chat) // не у всех методов есть chat_id
+	fake.mutex.Lock()
+	blocked := fake.Blocked[chat.ChatId]
+	fake.mutex.Unlock()
+	if blocked {
+		if onBlocked != nil {
+			onBlocked()
+		}
+		return errors.New(`403 403 Forbidden: bot was blocked by the user`)
+	}
+	return nil
+}
+
+func (fake *FakeTelegram) DoWithRetry(method string, payload []byte) (int, []byte, error) {
+	fake.record(method, payload)
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if response, ok := fake.Responses[method]; ok {
+		return 200, response, nil
+	}
+	return 200, []byte(`{"ok":true,"result":{}}`), nil
+}
+
+// DoWithTimeout отдает getUpdates из Updates, а если их нет - ждет timeout, как настоящий long polling.
+func (fake *FakeTelegram) DoWithTimeout(method string, payload []byte, timeout time.Duration) (int, []byte, error) {
+	if method != `getUpdates` {
+		return fake.DoWithRetry(method, payload)
+	}
+	updates := make([]Update, 0)
+	select {
+	case update := <-fake.Updates:
+		updates = append(updates, update)
+	case <-time.After(timeout):
+	}
+	data, err := json.Marshal(map[string]interface{}{`ok`: true, `result`: updates})
+	return 200, data, err
+}
+
+func (fake *FakeTelegram) GetMe() (telegram.Me, error) {
+	return fake.Me, nil
+}
+
+// Requests возвращает запросы с методом method (все, если он пустой).
+func (fake *FakeTelegram) Requests(method string) []FakeTelegramRequest {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	requests := make([]FakeTelegramRequest, 0)
+	for _, request := range fake.requests {
+		if method == `` || request.Method == method {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// Messages - тексты sendMessage, отправленные в чат chatId.
+func (fake *FakeTelegram) Messages(chatId int) []string {
+	messages := make([]string, 0)
+	for _, request := range fake.Requests(`sendMessage`) {
+		var message struct {
+			ChatId int    `json:"chat_id"`
+			Text   string `json:"text"`
+		}
+		if err := json.Unmarshal(request.Payload, &message); err != nil {
+			continue
+		}
+		if message.ChatId == chatId {
+			messages = append(messages, message.Text)
+		}
+	}
+	return messages
+}
+
+func NewFakeTelegram(username string) *FakeTelegram {
+	fake := FakeTelegram{
+		Me:        telegram.Me{Id: 1, IsBot: true, FirstName: username, Username: username},
+		requests:  make([]FakeTelegramRequest, 0),
+		Blocked:   make(map[int]bool),
+		Responses: make(map[string][]byte),
+		Updates:   make(chan Update),
+	}
+	fake.Responses[`getChatMember`] = []byte(`{"ok":true,"result":{"status":"administrator"}}`)
+	return &fake
+}
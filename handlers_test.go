@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/vvampirius/mygolibs/telegram"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel>
+<title>Onliner Auto</title>
+<link>https://auto.onliner.by/</link>
+<item>
+<guid>https://auto.onliner.by/2024/01/02/second</guid>
+<title>Вторая новость</title>
+<link>https://auto.onliner.by/2024/01/02/second</link>
+<category>Машины</category>
+<pubDate>Tue, 02 Jan 2024 12:00:00 +0300</pubDate>
+</item>
+<item>
+<guid>https://auto.onliner.by/2024/01/01/first</guid>
+<title>Первая новость</title>
+<link>https://auto.onliner.by/2024/01/01/first</link>
+<category>Дороги</category>
+<pubDate>Mon, 01 Jan 2024 12:00:00 +0300</pubDate>
+</item>
+</channel></rss>`
+
+// newTestCore - Core с FakeTelegram и base_dir во временном каталоге.
+func newTestCore(t *testing.T, fake *FakeTelegram, configure func(config *Config)) *Core {
+	t.Helper()
+	config := Config{}
+	config.Telegram.Token = `test`
+	config.Telegram.Mode = `polling`
+	config.Telegram.SendInterval = time.Nanosecond
+	config.BaseDir = t.TempDir()
+	config.StartMessage = `Привет!`
+	if configure != nil {
+		configure(&config)
+	}
+	config.SetDefaults()
+	configFile := &ConfigFile{Config: &config}
+	state, err := NewState(path.Join(config.BaseDir, `state.yml`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := NewHistory(path.Join(config.BaseDir, `history.yml`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := NewCatalog(config.BaseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := NewCore(configFile, fake, state, history, catalog, fake.Me.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core
+}
+
+// waitBackground дожидается обработки, которую handler'ы запустили в фоне.
+func waitBackground(t *testing.T, core *Core) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := core.Background.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func postRss(core *Core, body string, header http.Header) int {
+	r := httptest.NewRequest(http.MethodPost, `/rss`, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	core.RssHttpHandler(w, r)
+	return w.Code
+}
+
+func postUpdate(t *testing.T, core *Core, update Update) int {
+	t.Helper()
+	data, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, `/`, strings.NewReader(string(data)))
+	w := httptest.NewRecorder()
+	core.TelegramHttpHandler(w, r)
+	waitBackground(t, core)
+	return w.Code
+}
+
+func privateChat(id int) telegram.Chat {
+	return telegram.Chat{Id: id, Type: `private`, FirstName: `Test`}
+}
+
+func commandUpdate(chatId int, text string) Update {
+	return Update{Id: chatId, Message: Message{Message: telegram.Message{
+		Id:   1,
+		From: telegram.User{Id: chatId, FirstName: `Test`, LanguageCode: `ru`},
+		Date: int(time.Now().Unix()),
+		Chat: privateChat(chatId),
+		Text: text,
+	}}}
+}
+
+func callbackUpdate(chatId, messageId int, data string) Update {
+	return Update{Id: chatId, CallbackQuery: CallbackQuery{
+		Id:   `callback`,
+		From: telegram.User{Id: chatId, FirstName: `Test`, LanguageCode: `ru`},
+		Message: telegram.Message{
+			Id:   messageId,
+			Date: int(time.Now().Unix()),
+			Chat: privateChat(chatId),
+		},
+		Data: data,
+	}}
+}
+
+// decodePayloads разбирает тела запросов метода method в v (указатель на слайс).
+func decodePayloads(t *testing.T, fake *FakeTelegram, method string, v interface{}) {
+	t.Helper()
+	payloads := make([]json.RawMessage, 0)
+	for _, request := range fake.Requests(method) {
+		payloads = append(payloads, request.Payload)
+	}
+	data, _ := json.Marshal(payloads)
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRssHttpHandlerDeliversToSubscribers(t *testing.T) {
+	fake := NewFakeTelegram(`test_bot`)
+	core := newTestCore(t, fake, func(config *Config) {
+		config.Rss.Tokens = map[string]string{`test`: `secret`}
+	})
+	for _, chatId := range []int{101, 102, 103} {
+		if code := postUpdate(t, core, commandUpdate(chatId, `/start`)); code != http.StatusOK {
+			t.Fatalf("/start from %d: %d", chatId, code)
+		}
+	}
+	fake.mutex.Lock()
+	fake.Blocked[103] = true
+	fake.mutex.Unlock()
+
+	if code := postRss(core, testFeed, nil); code != http.StatusUnauthorized {
+		t.Fatalf("without token: %d", code)
+	}
+	waitBackground(t, core)
+	if messages := fake.Messages(101); len(messages) != 1 {
+		t.Fatalf("unauthorized feed was delivered: %v", messages)
+	}
+
+	header := http.Header{}
+	header.Set(`Authorization`, `Bearer secret`)
+	if code := postRss(core, testFeed, header); code != http.StatusOK {
+		t.Fatalf("with token: %d", code)
+	}
+	waitBackground(t, core)
+	for _, chatId := range []int{101, 102} {
+		messages := fake.Messages(chatId)
+		// Приветствие и две новости от старой к новой
+		if len(messages) != 3 {
+			t.Fatalf("%d got %d messages: %v", chatId, len(messages), messages)
+		}
+		if !strings.HasSuffix(messages[1], `/first`) || !strings.HasSuffix(messages[2], `/second`) {
+			t.Errorf("%d got items in wrong order: %v", chatId, messages[1:])
+		}
+		if !strings.HasPrefix(messages[2], `#Машины`) {
+			t.Errorf("%d: no tags in %q", chatId, messages[2])
+		}
+	}
+	user, err := core.GetUser(103)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Blocked {
+		t.Error(`103 blocked the bot, but is not marked as blocked`)
+	}
+	// После блокировки вторая новость в 103 уже не отправляется
+	if attempts := len(fake.Messages(103)); attempts != 2 {
+		t.Errorf("103: %d sendMessage attempts, expected 2", attempts)
+	}
+
+	// Тот же фид повторно ничего не рассылает
+	if code := postRss(core, testFeed, header); code != http.StatusOK {
+		t.Fatalf("repeated feed: %d", code)
+	}
+	waitBackground(t, core)
+	if messages := fake.Messages(101); len(messages) != 3 {
+		t.Errorf("repeated feed was delivered again: %v", messages)
+	}
+}
+
+func TestTelegramHttpHandlerRejectsWrongSecretToken(t *testing.T) {
+	fake := NewFakeTelegram(`test_bot`)
+	core := newTestCore(t, fake, func(config *Config) {
+		config.Telegram.SecretToken = `s3cret`
+	})
+	if code := postUpdate(t, core, commandUpdate(101, `/start`)); code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", code)
+	}
+	if requests := fake.Requests(``); len(requests) != 0 {
+		t.Errorf("rejected update was processed: %v", requests)
+	}
+}
+
+func TestTelegramHttpHandlerGeneratesSecretToken(t *testing.T) {
+	fake := NewFakeTelegram(`test_bot`)
+	core := newTestCore(t, fake, func(config *Config) {
+		config.Telegram.Mode = `webhook`
+		config.Telegram.Webhook = `https://bot.example.com/`
+	})
+	if err := core.SetWebhook(); err != nil {
+		t.Fatal(err)
+	}
+	var webhooks []SetWebhook
+	decodePayloads(t, fake, `setWebhook`, &webhooks)
+	if len(webhooks) != 1 || len(webhooks[0].SecretToken) < 32 {
+		t.Fatalf("no generated secret token in setWebhook: %+v", webhooks)
+	}
+	if code := postUpdate(t, core, commandUpdate(101, `/start`)); code != http.StatusForbidden {
+		t.Fatalf("without secret token: expected 403, got %d", code)
+	}
+	data, _ := json.Marshal(commandUpdate(101, `/start`))
+	r := httptest.NewRequest(http.MethodPost, `/`, strings.NewReader(string(data)))
+	r.Header.Set(`X-Telegram-Bot-Api-Secret-Token`, webhooks[0].SecretToken)
+	w := httptest.NewRecorder()
+	core.TelegramHttpHandler(w, r)
+	waitBackground(t, core)
+	if w.Code != http.StatusOK || len(fake.Messages(101)) != 1 {
+		t.Errorf("update with generated secret token: %d, %v", w.Code, fake.Messages(101))
+	}
+}
+
+func TestTelegramHttpHandlerCallbacks(t *testing.T) {
+	fake := NewFakeTelegram(`test_bot`)
+	core := newTestCore(t, fake, nil)
+	postUpdate(t, core, commandUpdate(101, `/start`))
+	postRss(core, testFeed, nil)
+	waitBackground(t, core)
+
+	var answers []struct {
+		Text      string `json:"text"`
+		ShowAlert bool   `json:"show_alert"`
+	}
+
+	// Отключение категории: ответ на callback и новые кнопки в том же сообщении
+	postUpdate(t, core, callbackUpdate(101, 55, `exclude|Машины`))
+	decodePayloads(t, fake, `answerCallbackQuery`, &answers)
+	if len(answers) != 1 || answers[0].Text != `⛔️Машины` {
+		t.Fatalf("unexpected answers: %+v", answers)
+	}
+	var markupEdits []struct {
+		ChatId      int                           `json:"chat_id"`
+		MessageId   int                           `json:"message_id"`
+		ReplyMarkup telegram.InlineKeyboardMarkup `json:"reply_markup"`
+	}
+	decodePayloads(t, fake, `editMessageReplyMarkup`, &markupEdits)
+	if len(markupEdits) != 1 || markupEdits[0].ChatId != 101 || markupEdits[0].MessageId != 55 {
+		t.Fatalf("unexpected edits: %+v", markupEdits)
+	}
+	found := false
+	for _, row := range markupEdits[0].ReplyMarkup.InlineKeyboard {
+		if row[0].CallbackData == `include|Машины` {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no include button in %+v", markupEdits[0].ReplyMarkup)
+	}
+	user, _ := core.GetUser(101)
+	if !user.IsInExcludedCategories(`Машины`) {
+		t.Error(`category is not excluded`)
+	}
+
+	// Закладка: сохранение, /saved и удаление с правкой списка
+	item := core.History.Last(1, nil)[0]
+	postUpdate(t, core, callbackUpdate(101, 56, fmt.Sprintf("save|%d", item.Id)))
+	decodePayloads(t, fake, `answerCallbackQuery`, &answers)
+	if len(answers) != 2 || answers[1].Text != core.Catalog.Text(`ru`, `bookmark_saved`) {
+		t.Fatalf("unexpected answers: %+v", answers)
+	}
+	postUpdate(t, core, commandUpdate(101, `/saved`))
+	messages := fake.Messages(101)
+	if saved := messages[len(messages)-1]; !strings.Contains(saved, item.Link) {
+		t.Fatalf("bookmark is not in /saved: %q", saved)
+	}
+	postUpdate(t, core, callbackUpdate(101, 57, fmt.Sprintf("unsave|%d|0", item.Id)))
+	var textEdits []struct {
+		MessageId int    `json:"message_id"`
+		Text      string `json:"text"`
+	}
+	decodePayloads(t, fake, `editMessageText`, &textEdits)
+	if len(textEdits) != 1 || textEdits[0].MessageId != 57 ||
+		textEdits[0].Text != core.Catalog.Text(`ru`, `bookmarks_empty`) {
+		t.Fatalf("unexpected edits: %+v", textEdits)
+	}
+
+	// Неизвестный callback - ответ с ошибкой
+	postUpdate(t, core, callbackUpdate(101, 58, `nonsense`))
+	decodePayloads(t, fake, `answerCallbackQuery`, &answers)
+	if last := answers[len(answers)-1]; !last.ShowAlert || last.Text != core.Catalog.Text(`ru`, `unknown_command`) {
+		t.Errorf("unexpected answer: %+v", last)
+	}
+}
+
+func TestTelegramHttpHandlerMigratesGroupToSupergroup(t *testing.T) {
+	fake := NewFakeTelegram(`test_bot`)
+	core := newTestCore(t, fake, nil)
+	group := telegram.Chat{Id: -100, Type: `group`, Title: `Test`}
+	user, err := core.GetOrCreateChat(group, telegram.User{Id: 101})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.AddExcludedCategory(`Машины`); err != nil {
+		t.Fatal(err)
+	}
+	update := Update{Id: 1, Message: Message{
+		Message:         telegram.Message{Id: 2, Date: int(time.Now().Unix()), Chat: group},
+		MigrateToChatId: -1001,
+	}}
+	if code := postUpdate(t, core, update); code != http.StatusOK {
+		t.Fatalf("migration update: %d", code)
+	}
+	if old, _ := core.GetUser(-100); old.Id() != 0 {
+		t.Errorf("old chat is still subscribed: %+v", old)
+	}
+	migrated, err := core.GetUser(-1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Id() != -1001 || migrated.Chat.Type != `supergroup` || !migrated.IsInExcludedCategories(`Машины`) {
+		t.Errorf("subscription is not migrated: %+v", migrated)
+	}
+}
+
+func TestBroadcastSendsConfirmedPreview(t *testing.T) {
+	fake := NewFakeTelegram(`test_bot`)
+	core := newTestCore(t, fake, nil)
+	postUpdate(t, core, commandUpdate(101, `/start`))
+	postUpdate(t, core, commandUpdate(102, `/start`))
+	admin, _ := core.GetUser(101)
+	admin.IsAdmin = true
+	if err := admin.Save(); err != nil {
+		t.Fatal(err)
+	}
+	postUpdate(t, core, commandUpdate(101, `/broadcast A`))
+	postUpdate(t, core, commandUpdate(101, `/broadcast B`))
+	var previews []struct {
+		ChatId      int                           `json:"chat_id"`
+		ReplyMarkup telegram.InlineKeyboardMarkup `json:"reply_markup"`
+	}
+	decodePayloads(t, fake, `sendMessage`, &previews)
+	buttons := make([]string, 0)
+	for _, preview := range previews {
+		if preview.ChatId == 101 && len(preview.ReplyMarkup.InlineKeyboard) > 0 {
+			buttons = append(buttons, preview.ReplyMarkup.InlineKeyboard[0][0].CallbackData)
+		}
+	}
+	if len(buttons) != 2 || buttons[0] == buttons[1] {
+		t.Fatalf("unexpected send buttons: %v", buttons)
+	}
+
+	// "Отправить" под первым предпросмотром шлет A, а не последнюю подготовленную B
+	postUpdate(t, core, callbackUpdate(101, 60, buttons[0]))
+	messages := fake.Messages(102)
+	if last := messages[len(messages)-1]; last != `A` {
+		t.Fatalf("expected A to be broadcast, got %q", last)
+	}
+	// Повторное нажатие ничего не шлет
+	postUpdate(t, core, callbackUpdate(101, 60, buttons[0]))
+	if again := fake.Messages(102); len(again) != len(messages) {
+		t.Errorf("broadcast was sent twice: %v", again)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type Health struct {
+	Status       string    `json:"status"`
+	LastIngestAt time.Time `json:"last_ingest_at"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// HealthHttpHandler отвечает 503, если фид не приходил дольше Health.MaxIngestAge. Пока фида не было ни разу,
+// отсчет идет от старта.
+func (core *Core) HealthHttpHandler(w http.ResponseWriter, r *http.Request) {
+	health := Health{
+		Status:       `ok`,
+		LastIngestAt: core.State.GetLastIngestAt(),
+	}
+	since := health.LastIngestAt
+	if since.IsZero() || since.Before(core.StartedAt) {
+		since = core.StartedAt
+	}
+	maxIngestAge := core.ConfigFile.Get().Health.MaxIngestAge
+	if time.Since(since) > maxIngestAge {
+		health.Status = `stale`
+		health.Reason = `no feed for ` + time.Since(since).Round(time.Second).String()
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	if health.Status != `ok` {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		Log.Error(`Can't write response`, `error`, err.Error())
+	}
+}
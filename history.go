@@ -0,0 +1,158 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+	"os"
+	"sync"
+	"time"
+)
+
+// HistoryLimit - сколько последних итемов хранить.
+const HistoryLimit = 200
+
+type Delivery struct {
+	Sent    int
+	Skipped int // отключена категория
+	Failed  int
+}
+
+type HistoryItem struct {
+	Id          int
+	Guid        string
+	Title       string
+	Link        string
+	Categories  []string
+	Description string // без HTML
+	Published   time.Time
+	Delivery    Delivery
+}
+
+// History - последние полученные итемы, старые в начале.
+type History struct {
+	path    string
+	mutex   sync.Mutex
+	onEvict func(id int)
+	LastId  int `yaml:"last_id"`
+	Items   []HistoryItem
+}
+
+func (history *History) Load() error {
+	f, err := os.Open(history.path)
+	if err != nil {
+		ErrorLog.Println(err.Error())
+		return err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	if err := decoder.Decode(history); err != nil {
+		ErrorLog.Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+// save - вызывать под mutex. По checkpoint'у из State рассылка продолжается с итемом из History, поэтому пишем так
+// же атомарно.
+func (history *History) save() error {
+	if err := writeYamlAtomic(history.path, history); err != nil {
+		Log.Error(`Can't save history`, `path`, history.path, `error`, err.Error())
+		return err
+	}
+	return nil
+}
+
+// OnEvict задает, что делать с итемом, который вытеснен из History (например удалить закешированную статью).
+func (history *History) OnEvict(f func(id int)) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	history.onEvict = f
+}
+
+// Add сохраняет итем, присваивая ему Id, и возвращает этот Id.
+func (history *History) Add(item HistoryItem) (int, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	history.LastId++
+	item.Id = history.LastId
+	history.Items = append(history.Items, item)
+	var evicted []HistoryItem
+	if len(history.Items) > HistoryLimit {
+		evicted = history.Items[:len(history.Items)-HistoryLimit]
+		history.Items = history.Items[len(history.Items)-HistoryLimit:]
+	}
+	err := history.save()
+	if history.onEvict != nil {
+		for _, evictedItem := range evicted {
+			history.onEvict(evictedItem.Id)
+		}
+	}
+	return item.Id, err
+}
+
+func (history *History) SetDelivery(id int, delivery Delivery) error {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	for i := range history.Items {
+		if history.Items[i].Id == id {
+			history.Items[i].Delivery = delivery
+			return history.save()
+		}
+	}
+	return os.ErrNotExist
+}
+
+func (history *History) Get(id int) (HistoryItem, bool) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	for _, item := range history.Items {
+		if item.Id == id {
+			return item, true
+		}
+	}
+	return HistoryItem{}, false
+}
+
+// Last возвращает до n последних итемов, для которых filter вернул true (nil - все), новые в начале.
+func (history *History) Last(n int, filter func(HistoryItem) bool) []HistoryItem {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	items := make([]HistoryItem, 0)
+	for i := len(history.Items) - 1; i >= 0 && len(items) < n; i-- {
+		if filter == nil || filter(history.Items[i]) {
+			items = append(items, history.Items[i])
+		}
+	}
+	return items
+}
+
+// Search возвращает итемы, в заголовке или описании которых есть все слова запроса (с учетом окончаний), новые
+// в начале.
+func (history *History) Search(query string) []HistoryItem {
+	queryStems := stemWords(query)
+	if len(queryStems) == 0 {
+		return nil
+	}
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	items := make([]HistoryItem, 0)
+	for i := len(history.Items) - 1; i >= 0; i-- {
+		item := history.Items[i]
+		if matchStems(stemWords(item.Title+` `+item.Description), queryStems) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func NewHistory(path string) (*History, error) {
+	history := History{
+		path: path,
+	}
+	if err := history.Load(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		history.Items = make([]HistoryItem, 0)
+	}
+	return &history, nil
+}
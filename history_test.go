@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHistoryAddRemovesEvictedArticles(t *testing.T) {
+	core := newTestCore(t, NewFakeTelegram(`test_bot`), nil)
+	for i := 0; i <= HistoryLimit; i++ {
+		id, err := core.History.Add(HistoryItem{Title: `item`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if err := core.SaveArticle(id, &Article{Text: `text`}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := os.Stat(core.ArticlePath(1)); !os.IsNotExist(err) {
+		t.Errorf("article of evicted item is not removed: %v", err)
+	}
+	if _, err := core.LoadArticle(2); err != nil {
+		t.Errorf("article of kept item is removed: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultLanguage - язык подписок, созданных до появления переводов, и запасной для недостающих ключей.
+	DefaultLanguage = `ru`
+	// ForeignLanguage - для пользователей, чьего языка нет в каталоге.
+	ForeignLanguage = `en`
+)
+
+//go:embed locales/*.yml
+var localeFiles embed.FS
+
+// Catalog - тексты бота по языкам: язык -> ключ -> текст (формат fmt.Sprintf).
+type Catalog struct {
+	mutex    sync.RWMutex
+	messages map[string]map[string]string
+}
+
+func loadLocale(fsys fs.FS, name string, messages map[string]map[string]string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	locale := make(map[string]string)
+	if err := yaml.Unmarshal(data, &locale); err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	language := strings.TrimSuffix(path.Base(name), `.yml`)
+	if messages[language] == nil {
+		messages[language] = make(map[string]string)
+	}
+	for key, text := range locale {
+		messages[language][key] = text
+	}
+	return nil
+}
+
+// Load читает встроенные переводы, а поверх них - <baseDir>/locales/*.yml.
+func (catalog *Catalog) Load(baseDir string) error {
+	messages := make(map[string]map[string]string)
+	names, err := fs.Glob(localeFiles, `locales/*.yml`)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := loadLocale(localeFiles, name, messages); err != nil {
+			ErrorLog.Println(err.Error())
+			return err
+		}
+	}
+	baseDirFs := os.DirFS(baseDir)
+	names, err = fs.Glob(baseDirFs, `locales/*.yml`)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := loadLocale(baseDirFs, name, messages); err != nil {
+			ErrorLog.Println(err.Error())
+			return err
+		}
+	}
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	catalog.messages = messages
+	return nil
+}
+
+// Text возвращает перевод key. Если в языке нет ключа - берется DefaultLanguage, если нет и там - сам ключ.
+func (catalog *Catalog) Text(language, key string, args ...interface{}) string {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	text, ok := catalog.messages[language][key]
+	if !ok {
+		text, ok = catalog.messages[DefaultLanguage][key]
+	}
+	if !ok {
+		Log.Error(`No text`, `key`, key, `language`, language)
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+func (catalog *Catalog) IsSupported(language string) bool {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	_, ok := catalog.messages[language]
+	return ok
+}
+
+// Languages - доступные языки по алфавиту.
+func (catalog *Catalog) Languages() []string {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	languages := make([]string, 0, len(catalog.messages))
+	for language := range catalog.messages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Detect выбирает язык по language_code из Telegram ("en", "pt-br"...).
+func (catalog *Catalog) Detect(languageCode string) string {
+	if languageCode == `` {
+		return DefaultLanguage
+	}
+	language := strings.ToLower(strings.SplitN(languageCode, `-`, 2)[0])
+	if catalog.IsSupported(language) {
+		return language
+	}
+	return ForeignLanguage
+}
+
+func NewCatalog(baseDir string) (*Catalog, error) {
+	catalog := Catalog{}
+	if err := catalog.Load(baseDir); err != nil {
+		return nil, err
+	}
+	return &catalog, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+const (
+	// InlineResultsLimit - сколько итемов отдавать на inline запрос (Telegram принимает до 50).
+	InlineResultsLimit = 20
+	// inlineDescriptionLength - описание в списке результатов все равно обрезается клиентом.
+	inlineDescriptionLength = 200
+	inlineCacheTime         = 60
+)
+
+// TelegramInlineQuery - "@AutoOnlinerByBot tesla": ищем по истории, на пустой запрос отдаем последние итемы.
+func (core *Core) TelegramInlineQuery(inlineQuery InlineQuery) {
+	Log.Debug(`Inline query`, `user_id`, inlineQuery.From.Id, `query`, inlineQuery.Query)
+	var items []HistoryItem
+	if inlineQuery.Query == `` {
+		items = core.History.Last(InlineResultsLimit, nil)
+	} else {
+		items = core.History.Search(inlineQuery.Query)
+		if len(items) > InlineResultsLimit {
+			items = items[:InlineResultsLimit]
+		}
+	}
+	results := make([]InlineQueryResultArticle, 0, len(items))
+	for _, item := range items {
+		description := []rune(item.Description)
+		if len(description) > inlineDescriptionLength {
+			description = append(description[:inlineDescriptionLength], '…')
+		}
+		results = append(results, InlineQueryResultArticle{
+			Type:  `article`,
+			Id:    strconv.Itoa(item.Id),
+			Title: item.Title,
+			InputMessageContent: InputTextMessageContent{
+				MessageText: core.ItemText(item),
+			},
+			Url:         item.Link,
+			Description: string(description),
+		})
+	}
+	payload := AnswerInlineQuery{
+		InlineQueryId: inlineQuery.Id,
+		Results:       results,
+		CacheTime:     inlineCacheTime,
+	}
+	if err := core.TelegramApi.RequestWrapper(`answerInlineQuery`, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+)
+
+// ChatLanguage - язык подписки чата, а если её нет - язык клиента пользователя from.
+func (core *Core) ChatLanguage(chatId int, from telegram.User) string {
+	if user, err := core.GetUser(chatId); err == nil && user.Language != `` {
+		return user.Language
+	}
+	return core.Catalog.Detect(from.LanguageCode)
+}
+
+func (core *Core) LanguageMarkup(user *User) telegram.InlineKeyboardMarkup {
+	markup := telegram.InlineKeyboardMarkup{InlineKeyboard: make([][]telegram.InlineKeyboardButton, 0)}
+	for _, language := range core.Catalog.Languages() {
+		text := core.Catalog.Text(language, `language_name`)
+		if language == user.Lang() {
+			text = `✅ ` + text
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []telegram.InlineKeyboardButton{{
+			Text:         text,
+			CallbackData: fmt.Sprintf("language|%s", language),
+		}})
+	}
+	return markup
+}
+
+// TelegramLanguage - /language: выбор языка бота для чата. В группах - только для администраторов.
+func (core *Core) TelegramLanguage(message telegram.Message) {
+	if !core.IsChatAdmin(message.Chat, message.From.Id) {
+		core.SendText(message.Chat.Id, core.Catalog.Text(core.ChatLanguage(message.Chat.Id, message.From), `admins_only`))
+		return
+	}
+	user, err := core.GetOrCreateChat(message.Chat, message.From)
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `get_user`}).Inc()
+		core.SendText(message.Chat.Id, core.Catalog.Text(core.ChatLanguage(message.Chat.Id, message.From), `error`))
+		return
+	}
+	payload := telegram.SendMessageIntWithInlineKeyboardMarkup{
+		ReplyMarkup: core.LanguageMarkup(user),
+	}
+	payload.ChatId = user.Id()
+	payload.Text = core.Catalog.Text(user.Lang(), `language_choose`)
+	if err := core.TelegramApi.RequestWrapper(``, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
+
+func (core *Core) LanguageCallback(update Update, user *User, language string) {
+	callbackId := update.CallbackQuery.Id
+	if !core.Catalog.IsSupported(language) {
+		core.AnswerCallback(callbackId, core.Catalog.Text(user.Lang(), `unknown_command`), true)
+		return
+	}
+	Log.Debug(`Set language`, `user_id`, user.Id(), `language`, language)
+	if err := user.SetLanguage(language); err != nil {
+		core.AnswerCallback(callbackId, core.Catalog.Text(user.Lang(), `settings_save_failed`), true)
+		return
+	}
+	core.AnswerCallback(callbackId, core.Catalog.Text(language, `language_set`,
+		core.Catalog.Text(language, `language_name`)), false)
+	markup := core.LanguageMarkup(user)
+	core.EditText(EditMessageText{
+		ChatId:      update.CallbackQuery.Message.Chat.Id,
+		MessageId:   update.CallbackQuery.Message.Id,
+		Text:        core.Catalog.Text(language, `language_choose`),
+		ReplyMarkup: &markup,
+	})
+}
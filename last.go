@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"github.com/vvampirius/mygolibs/telegram"
+	"strconv"
+)
+
+const (
+	// LastItemsDefault - сколько итемов присылать на /last без аргумента.
+	LastItemsDefault = 5
+	LastItemsMax     = 20
+	// WelcomeItems - сколько последних итемов присылать новому подписчику после /start.
+	WelcomeItems = 3
+)
+
+// SendLastItems присылает до n последних итемов из истории с учетом отключенных пользователем категорий.
+// Возвращает количество отправленных.
+func (core *Core) SendLastItems(user *User, n int) int {
+	items := core.History.Last(n, func(item HistoryItem) bool {
+		return !user.IsInExcludedCategories(item.Categories...)
+	})
+	// History.Last отдает новые в начале, а присылать надо в хронологическом порядке
+	for i := len(items) - 1; i >= 0; i-- {
+		if err := core.Deliver(context.Background(), user, core.ItemText(items[i]), core.ItemMarkup(items[i], user.Lang())); err != nil {
+			return len(items) - 1 - i
+		}
+		core.SendPause()
+	}
+	return len(items)
+}
+
+// TelegramLast - /last [n]
+func (core *Core) TelegramLast(message telegram.Message) {
+	user, err := core.GetOrCreateChat(message.Chat, message.From)
+	if err != nil {
+		core.SendText(message.Chat.Id, core.Catalog.Text(core.ChatLanguage(message.Chat.Id, message.From), `error`))
+		return
+	}
+	n := LastItemsDefault
+	if _, args := parseCommand(message.Text, core.BotUsername); args != `` {
+		n, err = strconv.Atoi(args)
+		if err != nil || n < 1 {
+			core.SendText(user.Id(), core.Catalog.Text(user.Lang(), `last_usage`))
+			return
+		}
+	}
+	if n > LastItemsMax {
+		n = LastItemsMax
+	}
+	if core.SendLastItems(user, n) == 0 {
+		core.SendText(user.Id(), core.Catalog.Text(user.Lang(), `last_empty`))
+	}
+}
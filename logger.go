@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+var (
+	// LogLevel меняется на лету при перезагрузке конфига.
+	LogLevel = new(slog.LevelVar)
+	Log      = slog.New(newLogHandler(``))
+	// ErrorLog и DebugLog - для мест, где нужен *log.Logger (например telegram.Api), пишут в тот же Log.
+	ErrorLog = slog.NewLogLogger(Log.Handler(), slog.LevelError)
+	DebugLog = slog.NewLogLogger(Log.Handler(), slog.LevelDebug)
+)
+
+func newLogHandler(format string) slog.Handler {
+	options := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     LogLevel,
+	}
+	if format == `json` {
+		return slog.NewJSONHandler(os.Stderr, options)
+	}
+	return slog.NewTextHandler(os.Stderr, options)
+}
+
+// SetupLogging настраивает формат (text или json) и уровень логов. Формат меняется только при старте.
+func SetupLogging(format, level string) error {
+	if err := LogLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level: %s", err.Error())
+	}
+	Log = slog.New(newLogHandler(format))
+	ErrorLog = slog.NewLogLogger(Log.Handler(), slog.LevelError)
+	DebugLog = slog.NewLogLogger(Log.Handler(), slog.LevelDebug)
+	// mygolibs пишет и в стандартный log
+	slog.SetDefault(Log)
+	log.SetFlags(0)
+	return nil
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ``
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger возвращает логгер с request_id (из X-Request-Id от прокси или новым) и отдает его в ответе.
+func requestLogger(w http.ResponseWriter, r *http.Request) *slog.Logger {
+	requestId := r.Header.Get(`X-Request-Id`)
+	if requestId == `` {
+		requestId = newRequestId()
+	}
+	w.Header().Set(`X-Request-Id`, requestId)
+	return Log.With(`request_id`, requestId)
+}
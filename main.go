@@ -1,27 +1,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/vvampirius/mygolibs/telegram"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
 )
 
 const VERSION = `0.4.2`
 
 var (
-	ErrorLog         = log.New(os.Stderr, `error#`, log.Lshortfile)
-	DebugLog         = log.New(os.Stdout, `debug#`, log.Lshortfile)
 	PrometheusErrors = prometheus.NewCounterVec(prometheus.CounterOpts{Name: `errors`,
 		Help: `Errors counter`}, []string{`action`})
-	PrometheusNewItems  = prometheus.NewCounter(prometheus.CounterOpts{Name: `new_items`, Help: `Received new items`})
+	PrometheusNewItems = prometheus.NewCounter(prometheus.CounterOpts{Name: `new_items`, Help: `Received new items`})
+	// PrometheusSendItems - без пользователя в метках: их число не ограничено, да и светить username незачем
 	PrometheusSendItems = prometheus.NewCounterVec(prometheus.CounterOpts{Name: `send_items`, Help: `Items to send`},
-		[]string{`username`})
+		[]string{`result`})
+	PrometheusRejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{Name: `rejected_requests`,
+		Help: `Rejected HTTP requests`}, []string{`handler`, `reason`})
+	PrometheusSendDuration = prometheus.NewHistogram(prometheus.HistogramOpts{Name: `send_duration_seconds`,
+		Help: `Duration of a single message delivery to Telegram`, Buckets: prometheus.DefBuckets})
+	PrometheusDeliveryLatency = prometheus.NewHistogram(prometheus.HistogramOpts{Name: `delivery_latency_seconds`,
+		Help:    `Time from item publication to the end of its broadcast`,
+		Buckets: prometheus.ExponentialBuckets(15, 2, 10)})
+	PrometheusTelegramResponses = prometheus.NewCounterVec(prometheus.CounterOpts{Name: `telegram_responses`,
+		Help: `Telegram Bot API responses by method and HTTP status code`}, []string{`method`, `code`})
 )
 
 func helpText() {
@@ -51,56 +60,111 @@ func main() {
 
 	fmt.Printf("Starting version %s...\n", VERSION)
 
-	if err := prometheus.Register(PrometheusErrors); err != nil {
-		ErrorLog.Println(err.Error())
+	for _, collector := range []prometheus.Collector{PrometheusErrors, PrometheusNewItems, PrometheusSendItems,
+		PrometheusRejectedRequests, PrometheusSendDuration, PrometheusDeliveryLatency, PrometheusTelegramResponses} {
+		if err := prometheus.Register(collector); err != nil {
+			ErrorLog.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	configFile, err := NewConfigFile(*configFilePath)
+	if err != nil {
 		os.Exit(1)
 	}
-	if err := prometheus.Register(PrometheusNewItems); err != nil {
+	if err := SetupLogging(configFile.Get().Log.Format, configFile.Get().Log.Level); err != nil {
 		ErrorLog.Println(err.Error())
 		os.Exit(1)
 	}
-	if err := prometheus.Register(PrometheusSendItems); err != nil {
+	tracing := configFile.Get().Tracing
+	shutdownTracing, err := SetupTracing(tracing.Exporter, tracing.Endpoint, tracing.Insecure)
+	if err != nil {
 		ErrorLog.Println(err.Error())
 		os.Exit(1)
 	}
 
-	configFile, err := NewConfigFile(*configFilePath)
+	// Коды ответов Telegram считаем на уровне транспорта: mygolibs их наружу не отдает
+	http.DefaultTransport = &TelegramMetricsTransport{
+		Transport: http.DefaultTransport,
+		ApiUrl:    configFile.Get().Telegram.ApiUrl,
+	}
+
+	var telegramApi TelegramClient = NewTelegramApi(configFile.Get().Telegram.ApiUrl, configFile.Get().Telegram.Token)
+	me, err := telegramApi.GetMe()
 	if err != nil {
 		os.Exit(1)
 	}
+	Log.Info(`Got info from Telegram API`, `username`, me.Username, `id`, me.Id, `name`, me.FirstName)
 
-	me, err := telegram.GetMe(configFile.Config.Telegram.Token)
+	state, err := NewState(path.Join(configFile.Get().BaseDir, `state.yml`))
 	if err != nil {
 		os.Exit(1)
 	}
-	DebugLog.Printf("Got info from Telegram API: @%s with ID:%d and name '%s'\n", me.Username, me.Id, me.FirstName)
 
-	if err := telegram.SetWebHook(configFile.Config.Telegram.Token, configFile.Config.Telegram.Webhook); err != nil {
-		ErrorLog.Println(err.Error())
+	history, err := NewHistory(path.Join(configFile.Get().BaseDir, `history.yml`))
+	if err != nil {
 		os.Exit(1)
 	}
-	DebugLog.Printf("Callback URL set to '%s'\n", configFile.Config.Telegram.Webhook)
-
-	telegramApi := telegram.NewApi(configFile.Config.Telegram.Token)
-	telegramApi.ErrorLog = ErrorLog
-	telegramApi.DebugLog = DebugLog
 
-	state, err := NewState(path.Join(configFile.Config.BaseDir, `state.yml`))
+	catalog, err := NewCatalog(configFile.Get().BaseDir)
 	if err != nil {
 		os.Exit(1)
 	}
 
-	core, err := NewCore(configFile, telegramApi, state)
+	core, err := NewCore(configFile, telegramApi, state, history, catalog, me.Username)
 	if err != nil {
 		os.Exit(1)
 	}
+	if err := prometheus.Register(&StatsCollector{core: core}); err != nil {
+		ErrorLog.Println(err.Error())
+		os.Exit(1)
+	}
 
-	server := http.Server{Addr: configFile.Config.Listen}
+	server := http.Server{Addr: configFile.Get().Listen}
+	http.HandleFunc(`/healthz`, core.HealthHttpHandler)
 	http.HandleFunc(`/ping`, Pong)
 	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc(`/rss`, core.RssHttpHandler)
-	http.HandleFunc(`/`, core.TelegramHttpHandler)
-	if err := server.ListenAndServe(); err != nil {
-		ErrorLog.Fatalln(err.Error())
+	WarnRssUnauthenticated(configFile.Get())
+	http.HandleFunc(`/api/`, core.ApiHttpHandler)
+	http.HandleFunc(`/admin/`, core.DashboardHttpHandler)
+
+	switch configFile.Get().Telegram.Mode {
+	case `webhook`:
+		if err := core.SetWebhook(); err != nil {
+			os.Exit(1)
+		}
+		Log.Info(`Callback URL set`, `webhook`, configFile.Get().Telegram.Webhook)
+		http.HandleFunc(`/`, core.TelegramHttpHandler)
+	case `polling`:
+		if err := core.DeleteWebhook(); err != nil {
+			os.Exit(1)
+		}
+		Log.Info(`Webhook deleted, using long polling`)
+		go core.PollingRoutine()
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			ErrorLog.Fatalln(err.Error())
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	Log.Info(`Shutting down...`, `signal`, sig.String())
+	ctx, cancel := context.WithTimeout(context.Background(), configFile.Get().ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		ErrorLog.Println(err.Error())
+	}
+	err = core.Shutdown(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		ErrorLog.Println(err.Error())
+	}
+	if err != nil {
+		os.Exit(1)
 	}
+	Log.Info(`Stopped`)
 }
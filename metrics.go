@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	statsUsersDesc = prometheus.NewDesc(`users`, `Subscribers by state (paused - blocked the bot, deactivated - via API)`,
+		[]string{`state`}, nil)
+	statsCategoriesDesc = prometheus.NewDesc(`categories`, `Known feed categories`, nil, nil)
+	statsLastIngestDesc = prometheus.NewDesc(`last_ingest_timestamp_seconds`,
+		`Time of the last successfully processed feed`, nil, nil)
+)
+
+// StatsCollector отдает те же цифры, что и /stats, считая их в момент запроса метрик.
+type StatsCollector struct {
+	core *Core
+}
+
+func (collector *StatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- statsUsersDesc
+	ch <- statsCategoriesDesc
+	ch <- statsLastIngestDesc
+}
+
+func (collector *StatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := collector.core.GetStats()
+	if err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `metrics`}).Inc()
+	} else {
+		ch <- prometheus.MustNewConstMetric(statsUsersDesc, prometheus.GaugeValue, float64(stats.Active), `active`)
+		ch <- prometheus.MustNewConstMetric(statsUsersDesc, prometheus.GaugeValue, float64(stats.Paused), `paused`)
+		ch <- prometheus.MustNewConstMetric(statsUsersDesc, prometheus.GaugeValue, float64(stats.Deactivated),
+			`deactivated`)
+	}
+	ch <- prometheus.MustNewConstMetric(statsCategoriesDesc, prometheus.GaugeValue,
+		float64(len(collector.core.State.GetCategories())))
+	if !stats.LastIngestAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(statsLastIngestDesc, prometheus.GaugeValue,
+			float64(stats.LastIngestAt.Unix()))
+	}
+}
+
+// TelegramMetricsTransport считает ответы Bot API (запросы на ApiUrl) по методам и кодам. Остальные запросы
+// пропускает как есть.
+type TelegramMetricsTransport struct {
+	Transport http.RoundTripper
+	ApiUrl    string
+}
+
+func (transport *TelegramMetricsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.Transport.RoundTrip(request)
+	if !strings.HasPrefix(request.URL.String(), transport.ApiUrl+`/bot`) {
+		return response, err
+	}
+	code := `error`
+	if err == nil {
+		code = strconv.Itoa(response.StatusCode)
+	}
+	// В пути есть токен, поэтому в метку идет только метод
+	PrometheusTelegramResponses.With(prometheus.Labels{`method`: path.Base(request.URL.Path), `code`: code}).Inc()
+	return response, err
+}
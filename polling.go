@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+	"time"
+)
+
+// PollingTimeout - сколько Telegram держит запрос getUpdates, если новых апдейтов нет.
+const PollingTimeout = 30
+
+// GetUpdates https://core.telegram.org/bots/api#getupdates
+type GetUpdates struct {
+	Offset  int `json:"offset"`
+	Timeout int `json:"timeout"`
+}
+
+// DeleteWebhook https://core.telegram.org/bots/api#deletewebhook
+type DeleteWebhook struct {
+	DropPendingUpdates bool `json:"drop_pending_updates"`
+}
+
+// DeleteWebhook - пока установлен webhook, getUpdates отвечает 409 Conflict.
+func (core *Core) DeleteWebhook() error {
+	if err := core.TelegramApi.RequestWrapper(`deleteWebhook`, DeleteWebhook{}, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+		return err
+	}
+	return nil
+}
+
+func (core *Core) GetUpdates(offset int) ([]Update, error) {
+	payload, err := telegram.JsonEncode(GetUpdates{Offset: offset, Timeout: PollingTimeout})
+	if err != nil {
+		Log.Error(`Can't encode getUpdates`, `error`, err.Error())
+		return nil, err
+	}
+	// Обычный таймаут API в несколько секунд меньше, чем Telegram держит long polling запрос
+	_, data, err := core.TelegramApi.DoWithTimeout(`getUpdates`, payload, (PollingTimeout+10)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Ok          bool     `json:"ok"`
+		Description string   `json:"description"`
+		Result      []Update `json:"result"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		Log.Error(`Can't parse getUpdates`, `response`, string(data), `error`, err.Error())
+		return nil, err
+	}
+	if !response.Ok {
+		err := errors.New(response.Description)
+		Log.Error(`getUpdates failed`, `error`, err.Error())
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+// PollingRoutine получает апдейты через getUpdates и передает их в тот же DispatchUpdate, что и TelegramHttpHandler.
+// Offset сохраняется в State, чтобы после рестарта не обрабатывать апдейты повторно. При остановке полученные, но не
+// начатые апдейты не подтверждаются - Telegram отдаст их снова после рестарта.
+func (core *Core) PollingRoutine() {
+	Log.Info(`Polling updates`, `offset`, core.State.GetUpdateOffset())
+	for !core.Background.IsStopping() {
+		updates, err := core.GetUpdates(core.State.GetUpdateOffset())
+		if core.Background.IsStopping() {
+			return
+		}
+		if err != nil {
+			PrometheusErrors.With(prometheus.Labels{`action`: `get_updates`}).Inc()
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		for _, update := range updates {
+			update := update
+			if !core.Background.Go(func() { core.DispatchUpdate(update, Log.With(`request_id`, newRequestId())) }) {
+				break
+			}
+			core.State.SetUpdateOffset(update.Id + 1)
+		}
+		if err := core.State.Save(); err != nil {
+			Log.Error(`Can't save state`, `error`, err.Error())
+		}
+	}
+}
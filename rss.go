@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/codes"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFeedUrl - фид, который забирается по запросу (например из API), если в конфиге не указан другой.
+const DefaultFeedUrl = `https://auto.onliner.by/feed`
+
+// DefaultRssMaxBodySize - фид auto.onliner.by весит около сотни килобайт, берем с запасом.
+const DefaultRssMaxBodySize = 5 << 20
+
+var rssAuditMutex sync.Mutex
+
+// WarnRssUnauthenticated предупреждает, что /rss открыт: без токенов и HMAC ключей фид может прислать кто угодно.
+func WarnRssUnauthenticated(config *Config) {
+	if len(config.Rss.Tokens) == 0 && len(config.Rss.HmacSecrets) == 0 {
+		Log.Warn(`/rss accepts feeds without authentication, set rss.tokens or rss.hmac_secrets`)
+	}
+}
+
+// AuthenticateRss проверяет запрос к /rss и возвращает имя клиента. Если ни токены, ни HMAC ключи не настроены -
+// принимаем всех (как раньше), клиент будет anonymous.
+func (core *Core) AuthenticateRss(r *http.Request, body []byte) (string, error) {
+	config := core.ConfigFile.Get().Rss
+	if len(config.Tokens) == 0 && len(config.HmacSecrets) == 0 {
+		return `anonymous`, nil
+	}
+
+	if client := r.Header.Get(`X-Rss-Client`); client != `` {
+		secret, ok := config.HmacSecrets[client]
+		if !ok {
+			return client, errors.New(`unknown client`)
+		}
+		signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(`X-Rss-Signature`), `sha256=`))
+		if err != nil {
+			return client, errors.New(`bad signature`)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return client, errors.New(`wrong signature`)
+		}
+		return client, nil
+	}
+
+	if authorization := r.Header.Get(`Authorization`); strings.HasPrefix(authorization, `Bearer `) {
+		token := strings.TrimPrefix(authorization, `Bearer `)
+		for client, clientToken := range config.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(clientToken)) == 1 {
+				return client, nil
+			}
+		}
+		return ``, errors.New(`wrong token`)
+	}
+
+	return ``, errors.New(`no credentials`)
+}
+
+// AuditRss дописывает в журнал, кто и что прислал на /rss.
+func (core *Core) AuditRss(r *http.Request, client, message string) {
+	if client == `` {
+		client = `-`
+	}
+	line := fmt.Sprintf("%s ip=%s client=%s ua=%q %s\n", time.Now().Format(time.RFC3339), core.RemoteIp(r), client,
+		r.UserAgent(), message)
+
+	rssAuditMutex.Lock()
+	defer rssAuditMutex.Unlock()
+	f, err := os.OpenFile(core.ConfigFile.Get().Rss.AuditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		Log.Error(`Can't open RSS audit log`, `error`, err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line); err != nil {
+		Log.Error(`Can't write RSS audit log`, `error`, err.Error())
+	}
+}
+
+func auditItems(total int, newItems []*gofeed.Item) string {
+	s := fmt.Sprintf("accepted: items=%d new=%d", total, len(newItems))
+	for _, item := range newItems {
+		s = s + fmt.Sprintf("\n\t%s %s", item.Title, item.Link)
+	}
+	return s
+}
+
+// FetchFeed сам забирает фид (обычно его присылают на /rss) и рассылает новые итемы.
+func (core *Core) FetchFeed(logger *slog.Logger) ([]*gofeed.Item, error) {
+	url := core.ConfigFile.Get().Rss.Url
+	logger = logger.With(`feed`, url)
+	logger.Info(`Fetching feed`)
+	ctx, span := Tracer.Start(context.Background(), `FetchFeed`)
+	defer span.End()
+	feed, err := gofeed.NewParser().ParseURLWithContext(url, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error(`Can't fetch feed`, `error`, err.Error())
+		PrometheusErrors.With(prometheus.Labels{`action`: `fetch_rss`}).Inc()
+		return nil, err
+	}
+	return core.ProcessFeed(ctx, feed, logger), nil
+}
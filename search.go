@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vvampirius/mygolibs/telegram"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SearchPageSize - сколько результатов поиска показывать в одном сообщении.
+const SearchPageSize = 5
+
+// russianEndings - окончания, которые отрезаем для поиска без учета словоформ. Отсортированы по длине, чтобы
+// сначала отрезать самое длинное.
+var russianEndings = []string{
+	`ями`, `ами`, `ого`, `его`, `ому`, `ему`, `ыми`, `ими`, `ией`, `иях`, `ах`, `ях`, `ов`, `ев`, `ей`, `ий`, `ый`,
+	`ой`, `ая`, `яя`, `ое`, `ее`, `ые`, `ие`, `ом`, `ем`, `ам`, `ям`, `ую`, `юю`, `ых`, `их`, `ия`,
+	`а`, `я`, `о`, `е`, `ы`, `и`, `у`, `ю`, `ь`, `й`,
+}
+
+// stem - регистр, ё и окончание. Короткие слова не трогаем, иначе от них ничего не останется.
+func stem(word string) string {
+	word = strings.ReplaceAll(strings.ToLower(word), `ё`, `е`)
+	runes := []rune(word)
+	for _, ending := range russianEndings {
+		endingLength := len([]rune(ending))
+		if len(runes)-endingLength >= 3 && strings.HasSuffix(word, ending) {
+			return string(runes[:len(runes)-endingLength])
+		}
+	}
+	return word
+}
+
+func stemWords(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	stems := make([]string, 0, len(words))
+	for _, word := range words {
+		stems = append(stems, stem(word))
+	}
+	return stems
+}
+
+// matchStems - каждое слово запроса является началом какого-нибудь слова текста.
+func matchStems(textStems, queryStems []string) bool {
+	for _, queryStem := range queryStems {
+		found := false
+		for _, textStem := range textStems {
+			if strings.HasPrefix(textStem, queryStem) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchTtl - сколько хранится запрос, чтобы листать его результаты кнопками.
+const SearchTtl = 24 * time.Hour
+
+type search struct {
+	query     string
+	createdAt time.Time
+}
+
+// Searches - запросы по ID, который передается в callback_data (туда влезает только 64 байта). У каждого сообщения
+// с результатами свой ID, поэтому новый /search в группе не перелистывает старые результаты.
+type Searches struct {
+	mutex    sync.Mutex
+	lastId   int
+	searches map[int]search
+}
+
+// Add запоминает запрос, попутно удаляя устаревшие, и возвращает его ID.
+func (searches *Searches) Add(query string) int {
+	searches.mutex.Lock()
+	defer searches.mutex.Unlock()
+	if searches.searches == nil {
+		searches.searches = make(map[int]search)
+	}
+	now := time.Now()
+	for id, s := range searches.searches {
+		if now.Sub(s.createdAt) > SearchTtl {
+			delete(searches.searches, id)
+		}
+	}
+	searches.lastId++
+	searches.searches[searches.lastId] = search{query: query, createdAt: now}
+	return searches.lastId
+}
+
+func (searches *Searches) Get(id int) (string, bool) {
+	searches.mutex.Lock()
+	defer searches.mutex.Unlock()
+	s, ok := searches.searches[id]
+	if !ok || time.Since(s.createdAt) > SearchTtl {
+		return ``, false
+	}
+	return s.query, true
+}
+
+// SearchPage возвращает текст и кнопки для страницы page результатов поиска.
+func (core *Core) SearchPage(searchId int, query string, page int, language string) (string, telegram.InlineKeyboardMarkup) {
+	markup := telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{}}
+	items := core.History.Search(query)
+	if len(items) == 0 {
+		return core.Catalog.Text(language, `search_not_found`, query), markup
+	}
+	pages := (len(items) + SearchPageSize - 1) / SearchPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	text := core.Catalog.Text(language, `search_found`, query, len(items), page+1, pages) + "\n"
+	from := page * SearchPageSize
+	for i := from; i < len(items) && i < from+SearchPageSize; i++ {
+		text = text + fmt.Sprintf("\n%d. %s\n%s\n", i+1, items[i].Title, items[i].Link)
+	}
+	buttons := make([]telegram.InlineKeyboardButton, 0)
+	if page > 0 {
+		buttons = append(buttons, telegram.InlineKeyboardButton{Text: `◀️`, CallbackData: fmt.Sprintf("search|%d|%d", searchId, page-1)})
+	}
+	if page < pages-1 {
+		buttons = append(buttons, telegram.InlineKeyboardButton{Text: `▶️`, CallbackData: fmt.Sprintf("search|%d|%d", searchId, page+1)})
+	}
+	if len(buttons) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, buttons)
+	}
+	return text, markup
+}
+
+// TelegramSearch - /search <запрос>
+func (core *Core) TelegramSearch(message telegram.Message) {
+	language := core.ChatLanguage(message.Chat.Id, message.From)
+	_, query := parseCommand(message.Text, core.BotUsername)
+	if query == `` {
+		core.SendText(message.Chat.Id, core.Catalog.Text(language, `search_usage`))
+		return
+	}
+	searchId := core.Searches.Add(query)
+	text, markup := core.SearchPage(searchId, query, 0, language)
+	payload := telegram.SendMessageIntWithInlineKeyboardMarkup{
+		ReplyMarkup: markup,
+	}
+	payload.ChatId = message.Chat.Id
+	payload.Text = text
+	payload.DisableWebPagePreview = true
+	if err := core.TelegramApi.RequestWrapper(``, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+	}
+}
+
+func (core *Core) SearchCallback(update Update, data string) {
+	callbackId := update.CallbackQuery.Id
+	chatId := update.CallbackQuery.Message.Chat.Id
+	language := core.ChatLanguage(chatId, update.CallbackQuery.From)
+	var searchId, page int
+	if _, err := fmt.Sscanf(data, "%d|%d", &searchId, &page); err != nil {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `unknown_command`), true)
+		return
+	}
+	query, ok := core.Searches.Get(searchId)
+	if !ok {
+		core.AnswerCallback(callbackId, core.Catalog.Text(language, `search_stale`), true)
+		return
+	}
+	core.AnswerCallback(callbackId, ``, false)
+	text, markup := core.SearchPage(searchId, query, page, language)
+	core.EditText(EditMessageText{
+		ChatId:                chatId,
+		MessageId:             update.CallbackQuery.Message.Id,
+		Text:                  text,
+		DisableWebPagePreview: true,
+		ReplyMarkup:           &markup,
+	})
+}
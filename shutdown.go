@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Background отслеживает фоновые горутины (обработку апдейтов и рассылки), чтобы при остановке дождаться их.
+type Background struct {
+	waitGroup sync.WaitGroup
+	mutex     sync.Mutex
+	stopping  bool
+}
+
+// Go запускает f в горутине и учитывает её до завершения. После Stop ничего не запускает и возвращает false: Add
+// под тем же mutex, что и stopping, не может случиться после начала Wait.
+func (background *Background) Go(f func()) bool {
+	background.mutex.Lock()
+	defer background.mutex.Unlock()
+	if background.stopping {
+		return false
+	}
+	background.waitGroup.Add(1)
+	go func() {
+		defer background.waitGroup.Done()
+		f()
+	}()
+	return true
+}
+
+func (background *Background) Stop() {
+	background.mutex.Lock()
+	defer background.mutex.Unlock()
+	background.stopping = true
+}
+
+func (background *Background) IsStopping() bool {
+	background.mutex.Lock()
+	defer background.mutex.Unlock()
+	return background.stopping
+}
+
+// Wait ждет завершения всех горутин, но не дольше, чем живет ctx.
+func (background *Background) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown дожидается рассылок в пределах ctx и сохраняет State.
+func (core *Core) Shutdown(ctx context.Context) error {
+	core.Background.Stop()
+	DebugLog.Println(`Waiting for background deliveries...`)
+	err := core.Background.Wait(ctx)
+	if err != nil {
+		ErrorLog.Println(`Deliveries are not finished:`, err.Error())
+	}
+	if err := core.State.Save(); err != nil {
+		return err
+	}
+	return err
+}
@@ -1,15 +1,35 @@
 package main
 
 import (
+	"encoding/json"
 	"gopkg.in/yaml.v2"
 	"os"
+	"sync"
 	"time"
 )
 
+// Checkpoint - итем, рассылка которого начата, но не закончена. Сохраняется после каждой отправки, чтобы после
+// падения продолжить рассылку с того же места.
+type Checkpoint struct {
+	Guid      string    `json:"guid"`
+	Published time.Time `json:"published"`
+	HistoryId int       `yaml:"history_id" json:"history_id"`
+	Delivered []int     `json:"delivered"` // ID чатов, которым отправка уже была
+}
+
 type State struct {
-	path       string
-	LastDate   time.Time `yaml:"last_date"`
-	Categories []string
+	path     string
+	mutex    sync.Mutex
+	LastDate time.Time `yaml:"last_date" json:"last_date"`
+	// LastGuids - уже разосланные итемы с датой ровно LastDate, чтобы не потерять итемы с одинаковой датой
+	LastGuids    []string    `yaml:"last_guids" json:"last_guids"`
+	Checkpoint   *Checkpoint `json:"checkpoint"`
+	Categories   []string    `json:"categories"`
+	UpdateOffset int         `yaml:"update_offset" json:"update_offset"` // для режима polling
+	LastIngestAt time.Time   `yaml:"last_ingest_at" json:"last_ingest_at"`
+	// Сколько итемов доставлено подписчикам за DeliveredDate (2006-01-02)
+	DeliveredDate  string `yaml:"delivered_date" json:"delivered_date"`
+	DeliveredCount int    `yaml:"delivered_count" json:"delivered_count"`
 }
 
 func (state *State) Load() error {
@@ -27,22 +47,22 @@ func (state *State) Load() error {
 	return nil
 }
 
+// Save пишет state.yml атомарно (см. writeYamlAtomic): checkpoint сохраняется после каждой отправки, и обрезанный
+// при падении файл не дал бы боту запуститься.
 func (state *State) Save() error {
-	f, err := os.OpenFile(state.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
-		ErrorLog.Println(err.Error())
-		return err
-	}
-	defer f.Close()
-	encoder := yaml.NewEncoder(f)
-	if err := encoder.Encode(*state); err != nil {
-		ErrorLog.Println(err.Error())
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if err := writeYamlAtomic(state.path, state); err != nil {
+		Log.Error(`Can't save state`, `path`, state.path, `error`, err.Error())
 		return err
 	}
 	return nil
 }
 
-func (state *State) IsInCategories(category string) bool {
+// Поля State пишутся из обработки фида, polling'а и API, а Save кодирует их из других горутин, поэтому читать и
+// менять их нужно только через методы ниже (под mutex).
+
+func (state *State) isInCategories(category string) bool {
 	for _, v := range state.Categories {
 		if v == category {
 			return true
@@ -51,10 +71,18 @@ func (state *State) IsInCategories(category string) bool {
 	return false
 }
 
+func (state *State) IsInCategories(category string) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.isInCategories(category)
+}
+
 func (state *State) AddCategory(categories ...string) int {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
 	added := 0
 	for _, category := range categories {
-		if state.IsInCategories(category) {
+		if state.isInCategories(category) {
 			continue
 		}
 		state.Categories = append(state.Categories, category)
@@ -63,6 +91,174 @@ func (state *State) AddCategory(categories ...string) int {
 	return added
 }
 
+// GetCategories возвращает копию списка категорий.
+func (state *State) GetCategories() []string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	categories := make([]string, len(state.Categories))
+	copy(categories, state.Categories)
+	return categories
+}
+
+func (state *State) GetLastDate() time.Time {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.LastDate
+}
+
+// Patch меняет LastDate и Categories (из API). nil - не менять.
+func (state *State) Patch(lastDate *time.Time, categories *[]string) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if lastDate != nil {
+		state.LastDate = *lastDate
+	}
+	if categories != nil {
+		state.Categories = *categories
+	}
+}
+
+// MarshalJSON кодирует State под mutex (ответы API).
+func (state *State) MarshalJSON() ([]byte, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	type fields State
+	return json.Marshal((*fields)(state))
+}
+
+func (state *State) GetLastIngestAt() time.Time {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.LastIngestAt
+}
+
+func (state *State) SetLastIngestAt(t time.Time) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.LastIngestAt = t
+}
+
+func (state *State) GetUpdateOffset() int {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.UpdateOffset
+}
+
+// SetUpdateOffset подтверждает апдейты до offset. Назад offset не сдвигается.
+func (state *State) SetUpdateOffset(offset int) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if offset > state.UpdateOffset {
+		state.UpdateOffset = offset
+	}
+}
+
+// IsNew - итем еще не разослан полностью.
+func (state *State) IsNew(guid string, published time.Time) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if published.After(state.LastDate) {
+		return true
+	}
+	if !published.Equal(state.LastDate) {
+		return false
+	}
+	for _, lastGuid := range state.LastGuids {
+		if lastGuid == guid {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCheckpoint возвращает копию незаконченной рассылки итема или nil, если её нет.
+func (state *State) GetCheckpoint(guid string) *Checkpoint {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.Checkpoint == nil || state.Checkpoint.Guid != guid {
+		return nil
+	}
+	checkpoint := *state.Checkpoint
+	return &checkpoint
+}
+
+// StartItem начинает рассылку итема и сохраняет State. Если рассылка этого итема уже была начата, отметки об
+// отправках сохраняются.
+func (state *State) StartItem(guid string, published time.Time, historyId int) error {
+	state.mutex.Lock()
+	if state.Checkpoint == nil || state.Checkpoint.Guid != guid {
+		state.Checkpoint = &Checkpoint{
+			Guid:      guid,
+			Published: published,
+			Delivered: make([]int, 0),
+		}
+	}
+	state.Checkpoint.HistoryId = historyId
+	state.mutex.Unlock()
+	return state.Save()
+}
+
+// IsDelivered - отправка этому чату в текущей рассылке уже была.
+func (state *State) IsDelivered(chatId int) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.Checkpoint == nil {
+		return false
+	}
+	for _, id := range state.Checkpoint.Delivered {
+		if id == chatId {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkDelivered отмечает отправку чату в текущей рассылке и сразу сохраняет State.
+func (state *State) MarkDelivered(chatId int) error {
+	state.mutex.Lock()
+	if state.Checkpoint != nil {
+		state.Checkpoint.Delivered = append(state.Checkpoint.Delivered, chatId)
+	}
+	state.mutex.Unlock()
+	return state.Save()
+}
+
+// CompleteItem сдвигает LastDate на разосланный итем, убирает checkpoint и сохраняет State.
+func (state *State) CompleteItem(guid string, published time.Time) error {
+	state.mutex.Lock()
+	if published.After(state.LastDate) {
+		state.LastDate = published
+		state.LastGuids = []string{guid}
+	} else if published.Equal(state.LastDate) {
+		state.LastGuids = append(state.LastGuids, guid)
+	}
+	state.Checkpoint = nil
+	state.mutex.Unlock()
+	return state.Save()
+}
+
+// AddDelivered увеличивает счетчик доставленных за сегодня итемов.
+func (state *State) AddDelivered(n int) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	today := time.Now().Format(`2006-01-02`)
+	if state.DeliveredDate != today {
+		state.DeliveredDate = today
+		state.DeliveredCount = 0
+	}
+	state.DeliveredCount += n
+}
+
+// DeliveredToday - сколько итемов доставлено подписчикам сегодня.
+func (state *State) DeliveredToday() int {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.DeliveredDate != time.Now().Format(`2006-01-02`) {
+		return 0
+	}
+	return state.DeliveredCount
+}
+
 func NewState(path string) (*State, error) {
 	state := State{
 		path: path,
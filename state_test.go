@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"github.com/mmcdole/gofeed"
+	"github.com/vvampirius/mygolibs/telegram"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// crashingTelegram "убивает" рассылку перед отправкой номер sendsLeft+1: горутина завершается посреди SendItem, как
+// при падении процесса, и ничего после отправки уже не выполняется.
+type crashingTelegram struct {
+	*FakeTelegram
+	mutex     sync.Mutex
+	sendsLeft int
+}
+
+func (crashing *crashingTelegram) RequestWrapper(method string, payload interface{}, onBlocked func()) error {
+	if method == `` {
+		crashing.mutex.Lock()
+		if crashing.sendsLeft == 0 {
+			crashing.mutex.Unlock()
+			runtime.Goexit()
+		}
+		crashing.sendsLeft--
+		crashing.mutex.Unlock()
+	}
+	return crashing.FakeTelegram.RequestWrapper(method, payload, onBlocked)
+}
+
+func processFeed(t *testing.T, core *Core) {
+	t.Helper()
+	feed, err := gofeed.NewParser().ParseString(testFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		core.ProcessFeed(context.Background(), feed, Log)
+	}()
+	<-done
+}
+
+func TestSendItemResumesAfterCrash(t *testing.T) {
+	chatIds := []int{101, 102, 103, 104, 105}
+	fake := NewFakeTelegram(`test_bot`)
+	crashing := &crashingTelegram{FakeTelegram: fake, sendsLeft: 3}
+	baseDir := t.TempDir()
+	withBaseDir := func(config *Config) {
+		config.BaseDir = baseDir
+	}
+	core := newTestCore(t, fake, withBaseDir)
+	core.TelegramApi = crashing
+	for _, chatId := range chatIds {
+		if _, err := core.GetOrCreateChat(privateChat(chatId), telegram.User{Id: chatId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processFeed(t, core)
+	if sent := len(fake.Requests(`sendMessage`)); sent != 3 {
+		t.Fatalf("expected crash after 3 messages, sent %d", sent)
+	}
+
+	// Рестарт: State и History читаются с диска заново
+	core = newTestCore(t, fake, withBaseDir)
+	if checkpoint := core.State.GetCheckpoint(`https://auto.onliner.by/2024/01/01/first`); checkpoint == nil ||
+		len(checkpoint.Delivered) != 3 {
+		t.Fatalf("unexpected checkpoint: %+v", checkpoint)
+	}
+	processFeed(t, core)
+
+	for _, chatId := range chatIds {
+		messages := fake.Messages(chatId)
+		if len(messages) != 2 || !strings.HasSuffix(messages[0], `/first`) ||
+			!strings.HasSuffix(messages[1], `/second`) {
+			t.Errorf("%d got %v, expected first and second item once", chatId, messages)
+		}
+	}
+	if checkpoint := core.State.GetCheckpoint(`https://auto.onliner.by/2024/01/01/first`); checkpoint != nil {
+		t.Errorf("checkpoint is not cleared: %+v", checkpoint)
+	}
+	if history := core.History.Last(10, nil); len(history) != 2 {
+		t.Errorf("resumed item was added to history again: %+v", history)
+	}
+}
+
+func TestStateSaveLeavesNoTemporaryFiles(t *testing.T) {
+	baseDir := t.TempDir()
+	state, err := NewState(baseDir + `/state.yml`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetUpdateOffset(42)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != `state.yml` {
+		t.Errorf("unexpected files: %v", entries)
+	}
+	loaded, err := NewState(baseDir + `/state.yml`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset := loaded.GetUpdateOffset(); offset != 42 {
+		t.Errorf("loaded offset %d, expected 42", offset)
+	}
+}
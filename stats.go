@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"github.com/vvampirius/mygolibs/telegram"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statsTopCategories - сколько самых отключаемых категорий показывать в /stats.
+const statsTopCategories = 5
+
+type Stats struct {
+	Total              int
+	Active             int
+	Paused             int // заблокировали бота
+	Deactivated        int // отключены через API (даже если и заблокировали бота)
+	New                int // за последние 7 дней
+	ExcludedCategories []CategoryCount
+	DeliveredToday     int
+	LastIngestAt       time.Time
+	LastItemDate       time.Time
+}
+
+type CategoryCount struct {
+	Category string
+	Count    int
+}
+
+func (core *Core) GetStats() (Stats, error) {
+	stats := Stats{
+		DeliveredToday: core.State.DeliveredToday(),
+		LastIngestAt:   core.State.GetLastIngestAt(),
+		LastItemDate:   core.State.GetLastDate(),
+	}
+	users, err := core.GetUsers()
+	if err != nil {
+		return stats, err
+	}
+	weekAgo := time.Now().AddDate(0, 0, -7)
+	excluded := make(map[string]int)
+	for _, user := range users {
+		stats.Total++
+		switch {
+		case user.Deactivated:
+			stats.Deactivated++
+		case user.Blocked:
+			stats.Paused++
+		default:
+			stats.Active++
+		}
+		if user.CreatedAt.After(weekAgo) {
+			stats.New++
+		}
+		for _, category := range user.ExcludedCategories {
+			excluded[category]++
+		}
+	}
+	for category, count := range excluded {
+		stats.ExcludedCategories = append(stats.ExcludedCategories, CategoryCount{Category: category, Count: count})
+	}
+	sort.Slice(stats.ExcludedCategories, func(i, j int) bool {
+		if stats.ExcludedCategories[i].Count == stats.ExcludedCategories[j].Count {
+			return stats.ExcludedCategories[i].Category < stats.ExcludedCategories[j].Category
+		}
+		return stats.ExcludedCategories[i].Count > stats.ExcludedCategories[j].Count
+	})
+	return stats, nil
+}
+
+// Text - статистика для /stats на языке language.
+func (stats *Stats) Text(catalog *Catalog, language string) string {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return catalog.Text(language, `stats_never`)
+		}
+		return formatStatsTime(t)
+	}
+	s := catalog.Text(language, `stats`, stats.Total, stats.Active, stats.Paused, stats.Deactivated, stats.New, stats.DeliveredToday,
+		formatTime(stats.LastIngestAt), formatTime(stats.LastItemDate)) + "\n"
+	if len(stats.ExcludedCategories) > 0 {
+		top := make([]string, 0)
+		for i, category := range stats.ExcludedCategories {
+			if i == statsTopCategories {
+				break
+			}
+			top = append(top, fmt.Sprintf("%s: %d", category.Category, category.Count))
+		}
+		s = s + "\n" + catalog.Text(language, `stats_top_excluded`) + "\n" + strings.Join(top, "\n")
+	}
+	return s
+}
+
+func formatStatsTime(t time.Time) string {
+	if t.IsZero() {
+		return `никогда`
+	}
+	return t.Format("02.01 15:04:05 MST")
+}
+
+// TelegramStats - /stats для администраторов бота.
+func (core *Core) TelegramStats(message telegram.Message) {
+	user, err := core.GetUser(message.Chat.Id)
+	if err != nil || !user.IsPrivate() || !user.IsAdmin {
+		Log.Debug(`Not allowed to get stats`, `user_id`, message.From.Id)
+		return
+	}
+	stats, err := core.GetStats()
+	if err != nil {
+		core.SendText(user.Id(), core.Catalog.Text(user.Lang(), `stats_failed`, err.Error()))
+		return
+	}
+	core.SendText(user.Id(), stats.Text(core.Catalog, user.Lang()))
+}
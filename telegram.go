@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/vvampirius/mygolibs/telegram"
+	"strings"
+)
+
+// AnswerCallbackQuery https://core.telegram.org/bots/api#answercallbackquery
+type AnswerCallbackQuery struct {
+	CallbackQueryId string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+	ShowAlert       bool   `json:"show_alert,omitempty"`
+	CacheTime       int    `json:"cache_time,omitempty"`
+}
+
+// EditMessageText https://core.telegram.org/bots/api#editmessagetext
+type EditMessageText struct {
+	ChatId                int                            `json:"chat_id"`
+	MessageId             int                            `json:"message_id"`
+	Text                  string                         `json:"text"`
+	DisableWebPagePreview bool                           `json:"disable_web_page_preview,omitempty"`
+	ReplyMarkup           *telegram.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// isMessageNotModified - Telegram ругается, если новая разметка совпадает со старой. Для нас это не ошибка.
+func isMessageNotModified(err error) bool {
+	return err != nil && strings.Contains(err.Error(), `message is not modified`)
+}
+
+// isMessageNotEditable - сообщение слишком старое (или уже удалено) и отредактировать его нельзя.
+func isMessageNotEditable(err error) bool {
+	if err == nil {
+		return false
+	}
+	s := err.Error()
+	return strings.Contains(s, `message can't be edited`) || strings.Contains(s, `message to edit not found`)
+}
+
+// Message - telegram.Message с полями, которых нет в mygolibs.
+type Message struct {
+	telegram.Message
+	// MigrateToChatId - группа стала супергруппой с этим ID (служебное сообщение в старой группе)
+	MigrateToChatId int `json:"migrate_to_chat_id"`
+}
+
+// Update - telegram.Update из mygolibs не знает про channel_post, my_chat_member и отправителя callback'а, поэтому
+// разбираем апдейты сами.
+type Update struct {
+	Id            int               `json:"update_id"`
+	Message       Message           `json:"message"`
+	ChannelPost   telegram.Message  `json:"channel_post"`
+	CallbackQuery CallbackQuery     `json:"callback_query"`
+	MyChatMember  ChatMemberUpdated `json:"my_chat_member"`
+	InlineQuery   InlineQuery       `json:"inline_query"`
+}
+
+func (update *Update) IsMessage() bool {
+	return update.Message.Id != 0
+}
+
+func (update *Update) IsChannelPost() bool {
+	return update.ChannelPost.Id != 0
+}
+
+func (update *Update) IsCallbackQuery() bool {
+	return update.CallbackQuery.Id != ``
+}
+
+func (update *Update) IsMyChatMember() bool {
+	return update.MyChatMember.Chat.Id != 0
+}
+
+func (update *Update) IsInlineQuery() bool {
+	return update.InlineQuery.Id != ``
+}
+
+// CallbackQuery https://core.telegram.org/bots/api#callbackquery
+type CallbackQuery struct {
+	Id           string           `json:"id"`
+	From         telegram.User    `json:"from"`
+	Message      telegram.Message `json:"message"`
+	ChatInstance string           `json:"chat_instance"`
+	Data         string           `json:"data"`
+}
+
+// ChatMember https://core.telegram.org/bots/api#chatmember
+type ChatMember struct {
+	User   telegram.User `json:"user"`
+	Status string        `json:"status"`
+}
+
+// IsPresent - бот (или пользователь) находится в чате.
+func (chatMember *ChatMember) IsPresent() bool {
+	switch chatMember.Status {
+	case `creator`, `administrator`, `member`, `restricted`:
+		return true
+	}
+	return false
+}
+
+// IsAdmin - может менять настройки чата.
+func (chatMember *ChatMember) IsAdmin() bool {
+	return chatMember.Status == `creator` || chatMember.Status == `administrator`
+}
+
+// ChatMemberUpdated https://core.telegram.org/bots/api#chatmemberupdated
+type ChatMemberUpdated struct {
+	Chat          telegram.Chat `json:"chat"`
+	From          telegram.User `json:"from"`
+	Date          int           `json:"date"`
+	OldChatMember ChatMember    `json:"old_chat_member"`
+	NewChatMember ChatMember    `json:"new_chat_member"`
+}
+
+// InlineQuery https://core.telegram.org/bots/api#inlinequery
+type InlineQuery struct {
+	Id     string        `json:"id"`
+	From   telegram.User `json:"from"`
+	Query  string        `json:"query"`
+	Offset string        `json:"offset"`
+}
+
+// InlineQueryResultArticle https://core.telegram.org/bots/api#inlinequeryresultarticle
+type InlineQueryResultArticle struct {
+	Type                string                  `json:"type"`
+	Id                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+	Url                 string                  `json:"url,omitempty"`
+	Description         string                  `json:"description,omitempty"`
+}
+
+// InputTextMessageContent https://core.telegram.org/bots/api#inputtextmessagecontent
+type InputTextMessageContent struct {
+	MessageText string `json:"message_text"`
+}
+
+// AnswerInlineQuery https://core.telegram.org/bots/api#answerinlinequery
+type AnswerInlineQuery struct {
+	InlineQueryId string                     `json:"inline_query_id"`
+	Results       []InlineQueryResultArticle `json:"results"`
+	CacheTime     int                        `json:"cache_time"`
+}
+
+// GetChatMember https://core.telegram.org/bots/api#getchatmember
+type GetChatMember struct {
+	ChatId int `json:"chat_id"`
+	UserId int `json:"user_id"`
+}
+
+func UnmarshalUpdate(data []byte) (Update, error) {
+	update := Update{}
+	if err := json.Unmarshal(data, &update); err != nil {
+		return update, err
+	}
+	return update, nil
+}
+
+// isPrivateChat - пустой тип бывает у пользователей, сохраненных до появления поддержки групп.
+func isPrivateChat(chat telegram.Chat) bool {
+	return chat.Type == `` || chat.Type == `private`
+}
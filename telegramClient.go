@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vvampirius/mygolibs/telegram"
+	"time"
+)
+
+// TelegramClient - вызовы Bot API, которые нужны Core. Настоящий клиент - TelegramApi, в тестах - FakeTelegram.
+type TelegramClient interface {
+	// RequestWrapper отправляет запрос, не разбирая результат. onBlocked вызывается, если пользователь заблокировал
+	// бота.
+	RequestWrapper(method string, payload interface{}, onBlocked func()) error
+	// DoWithRetry отправляет уже закодированный запрос и отдает ответ как есть.
+	DoWithRetry(method string, payload []byte) (int, []byte, error)
+	// DoWithTimeout - то же без повторов, но со своим таймаутом (для long polling).
+	DoWithTimeout(method string, payload []byte, timeout time.Duration) (int, []byte, error)
+	GetMe() (telegram.Me, error)
+}
+
+// TelegramApi дополняет telegram.Api из mygolibs до TelegramClient.
+type TelegramApi struct {
+	*telegram.Api
+}
+
+func (api *TelegramApi) DoWithTimeout(method string, payload []byte, timeout time.Duration) (int, []byte, error) {
+	apiCopy := *api.Api
+	apiCopy.RequestTimeout = timeout
+	return apiCopy.Do(method, payload)
+}
+
+// GetMe - как telegram.GetMe, но через тот же Api (его Url, таймауты и повторы).
+func (api *TelegramApi) GetMe() (telegram.Me, error) {
+	_, data, err := api.DoWithRetry(`getMe`, []byte(`{}`))
+	if err != nil {
+		ErrorLog.Println(err.Error())
+		return telegram.Me{}, err
+	}
+	var response struct {
+		Ok          bool        `json:"ok"`
+		Description string      `json:"description"`
+		Result      telegram.Me `json:"result"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		ErrorLog.Println(string(data), err.Error())
+		return telegram.Me{}, err
+	}
+	if !response.Ok {
+		err := errors.New(response.Description)
+		ErrorLog.Println(err.Error())
+		return telegram.Me{}, err
+	}
+	return response.Result, nil
+}
+
+// NewTelegramApi создает клиент для Bot API по адресу apiUrl. Глобальный telegram.ApiUrl не трогаем: все запросы
+// идут через Api.
+func NewTelegramApi(apiUrl, token string) *TelegramApi {
+	api := TelegramApi{Api: telegram.NewApi(token)}
+	api.Url = apiUrl
+	api.ErrorLog = ErrorLog
+	api.DebugLog = DebugLog
+	return &api
+}
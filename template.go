@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus"
+	"text/template"
+)
+
+// DefaultItemTemplate - теги и ссылка, по которой Telegram сам показывает превью.
+const DefaultItemTemplate = "{{if .Tags}}{{.Tags}}\n\n{{end}}{{.Item.Link}}"
+
+// ItemTemplateData - что доступно в ItemTemplate. Article nil, если статьи не скачиваются или скачать не удалось.
+type ItemTemplateData struct {
+	Item    HistoryItem
+	Tags    string
+	Article *Article
+}
+
+func (core *Core) ItemTemplate() *template.Template {
+	t, err := template.New(`item`).Parse(core.ConfigFile.Get().ItemTemplate)
+	if err != nil {
+		Log.Error(`Can't parse item template`, `error`, err.Error())
+		PrometheusErrors.With(prometheus.Labels{`action`: `item_template`}).Inc()
+		return template.Must(template.New(`item`).Parse(DefaultItemTemplate))
+	}
+	return t
+}
+
+// ItemText - текст сообщения с итемом по ItemTemplate.
+func (core *Core) ItemText(item HistoryItem) string {
+	data := ItemTemplateData{
+		Item: item,
+		Tags: core.CategoriesToTagsString(item.Categories),
+	}
+	if core.ConfigFile.Get().Article.Enabled {
+		if article, err := core.LoadArticle(item.Id); err == nil {
+			data.Article = article
+		}
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := core.ItemTemplate().Execute(buffer, data); err != nil {
+		Log.Error(`Can't execute item template`, `item_id`, item.Id, `error`, err.Error())
+		PrometheusErrors.With(prometheus.Labels{`action`: `item_template`}).Inc()
+		return item.Link
+	}
+	return truncateText(buffer.String(), ArticleMessageLength)
+}
+
+// truncateText обрезает текст до limit символов (не байт), чтобы Telegram не отверг сообщение целиком.
+func truncateText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + `…`
+}
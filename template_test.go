@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestItemTextFitsMessageWithLongArticle(t *testing.T) {
+	core := newTestCore(t, NewFakeTelegram(`test_bot`), func(config *Config) {
+		config.Article.Enabled = true
+		config.ItemTemplate = "{{.Item.Title}}\n\n{{.Article.Text}}"
+	})
+	item := HistoryItem{Id: 1, Title: `Статья`, Link: `https://auto.onliner.by/2024/01/01/first`}
+	article := &Article{Url: item.Link, Text: strings.Repeat(`Длинный абзац статьи. `, 500)}
+	if err := core.SaveArticle(item.Id, article); err != nil {
+		t.Fatal(err)
+	}
+	text := core.ItemText(item)
+	if !utf8.ValidString(text) {
+		t.Errorf("text is cut inside a rune")
+	}
+	if length := utf8.RuneCountInString(text); length != ArticleMessageLength {
+		t.Errorf("text length %d, expected %d", length, ArticleMessageLength)
+	}
+	if !strings.HasPrefix(text, "Статья\n\nДлинный абзац") || !strings.HasSuffix(text, `…`) {
+		t.Errorf("unexpected text: %.100q...", text)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+const TracingServiceName = `onliner-auto-bot`
+
+// Tracer пишет в глобальный TracerProvider: пока трейсинг не настроен (см. SetupTracing), спаны никуда не уходят.
+var Tracer = otel.Tracer(`github.com/vvampirius/onliner-auto-bot`)
+
+// SetupTracing включает экспорт спанов в OTLP (HTTP) или stdout. Возвращает функцию, которая при остановке
+// досылает накопленные спаны.
+func SetupTracing(exporter, endpoint string, insecure bool) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ``:
+		return func(context.Context) error { return nil }, nil
+	case `stdout`:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case `otlp`:
+		// Без endpoint'а используются OTEL_EXPORTER_OTLP_* из окружения
+		options := make([]otlptracehttp.Option, 0)
+		if endpoint != `` {
+			options = append(options, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		spanExporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		err = fmt.Errorf("unknown exporter '%s'", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(TracingServiceName),
+			semconv.ServiceVersion(VERSION))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// endSpan отмечает ошибку (если есть) и закрывает спан.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+var (
+	attributeItemGuid = attribute.Key(`item.guid`)
+	attributeUserId   = attribute.Key(`user.id`)
+)
@@ -10,19 +10,69 @@ import (
 	"time"
 )
 
+var (
+	ErrAlreadyExcluded = errors.New(`already in`)
+	ErrNotExcluded     = errors.New(`not found`)
+	ErrBookmarked      = errors.New(`already bookmarked`)
+	ErrNotBookmarked   = errors.New(`not bookmarked`)
+)
+
+// BookmarksLimit - сколько закладок хранится у пользователя, старые вытесняются. /saved показывает их по
+// BookmarksPageSize на страницу.
+const BookmarksLimit = 50
+
+// Bookmark хранит копию итема: в History он со временем вытесняется.
+type Bookmark struct {
+	ItemId  int       `yaml:"item_id" json:"item_id"`
+	Title   string    `json:"title"`
+	Link    string    `json:"link"`
+	SavedAt time.Time `yaml:"saved_at" json:"saved_at"`
+}
+
+// User - подписка. Ключом является ID чата: для личных сообщений он совпадает с ID пользователя, для групп и каналов
+// в Info хранится тот, кто добавил бота.
 type User struct {
 	path               string
-	Info               telegram.User
-	CreatedAt          time.Time `yaml:"created_at"`
-	ExcludedCategories []string  `yaml:"excluded_categories"`
-	IsAdmin            bool      `yaml:"is_admin"`
+	Info               telegram.User `json:"info"`
+	Chat               telegram.Chat `json:"chat"`
+	CreatedAt          time.Time     `yaml:"created_at" json:"created_at"`
+	ExcludedCategories []string      `yaml:"excluded_categories" json:"excluded_categories"`
+	IsAdmin            bool          `yaml:"is_admin" json:"is_admin"`
+	Blocked            bool          `yaml:"blocked" json:"blocked"`         // пользователь заблокировал бота
+	Deactivated        bool          `yaml:"deactivated" json:"deactivated"` // отключен админом через API
+	Bookmarks          []Bookmark    `json:"bookmarks"`
+	Language           string        `yaml:"language" json:"language"` // пустой - DefaultLanguage
 }
 
 func (user *User) Id() int {
+	if user.Chat.Id != 0 {
+		return user.Chat.Id
+	}
 	return user.Info.Id
 }
 
+// Lang - язык, на котором боту писать в этот чат.
+func (user *User) Lang() string {
+	if user.Language == `` {
+		return DefaultLanguage
+	}
+	return user.Language
+}
+
+func (user *User) IsPrivate() bool {
+	return isPrivateChat(user.Chat)
+}
+
 func (user *User) Name() string {
+	if !user.IsPrivate() {
+		if user.Chat.Username != `` {
+			return `@` + user.Chat.Username
+		}
+		if user.Chat.Title != `` {
+			return user.Chat.Title
+		}
+		return fmt.Sprintf("%d", user.Id())
+	}
 	if user.Info.Username != `` {
 		return `@` + user.Info.Username
 	}
@@ -58,14 +108,14 @@ func (user *User) Load() error {
 func (user *User) Save() error {
 	f, err := os.OpenFile(user.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
-		ErrorLog.Println(err.Error())
+		Log.Error(`Can't save user`, `path`, user.path, `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `save`}).Inc()
 		return err
 	}
 	defer f.Close()
 	encoder := yaml.NewEncoder(f)
 	if err := encoder.Encode(*user); err != nil {
-		ErrorLog.Println(err.Error())
+		Log.Error(`Can't save user`, `path`, user.path, `error`, err.Error())
 		PrometheusErrors.With(prometheus.Labels{`action`: `save`}).Inc()
 		return err
 	}
@@ -85,9 +135,8 @@ func (user *User) IsInExcludedCategories(categories ...string) bool {
 
 func (user *User) AddExcludedCategory(category string) error {
 	if user.IsInExcludedCategories(category) {
-		err := errors.New(`already in`)
-		ErrorLog.Println(err.Error())
-		return err
+		ErrorLog.Println(ErrAlreadyExcluded.Error())
+		return ErrAlreadyExcluded
 	}
 	user.ExcludedCategories = append(user.ExcludedCategories, category)
 	return user.Save()
@@ -95,9 +144,8 @@ func (user *User) AddExcludedCategory(category string) error {
 
 func (user *User) RemoveExcludedCategory(category string) error {
 	if !user.IsInExcludedCategories(category) {
-		err := errors.New(`not found`)
-		ErrorLog.Println(err.Error())
-		return err
+		ErrorLog.Println(ErrNotExcluded.Error())
+		return ErrNotExcluded
 	}
 	newExcludedCategories := make([]string, 0)
 	for _, c := range user.ExcludedCategories {
@@ -109,6 +157,68 @@ func (user *User) RemoveExcludedCategory(category string) error {
 	return user.Save()
 }
 
+func (user *User) IsBookmarked(itemId int) bool {
+	for _, bookmark := range user.Bookmarks {
+		if bookmark.ItemId == itemId {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *User) AddBookmark(bookmark Bookmark) error {
+	if user.IsBookmarked(bookmark.ItemId) {
+		return ErrBookmarked
+	}
+	user.Bookmarks = append(user.Bookmarks, bookmark)
+	if len(user.Bookmarks) > BookmarksLimit {
+		user.Bookmarks = user.Bookmarks[len(user.Bookmarks)-BookmarksLimit:]
+	}
+	return user.Save()
+}
+
+func (user *User) RemoveBookmark(itemId int) error {
+	if !user.IsBookmarked(itemId) {
+		return ErrNotBookmarked
+	}
+	bookmarks := make([]Bookmark, 0)
+	for _, bookmark := range user.Bookmarks {
+		if bookmark.ItemId != itemId {
+			bookmarks = append(bookmarks, bookmark)
+		}
+	}
+	user.Bookmarks = bookmarks
+	return user.Save()
+}
+
+func (user *User) SetLanguage(language string) error {
+	user.Language = language
+	return user.Save()
+}
+
+// IsActive - можно ли слать в чат: бот не заблокирован и подписка не отключена через API.
+func (user *User) IsActive() bool {
+	return !user.Blocked && !user.Deactivated
+}
+
+// SetDeactivated отключает (или включает) подписку. Меняется только через API, сообщения пользователя его не сбрасывают.
+func (user *User) SetDeactivated(deactivated bool) error {
+	if user.Deactivated == deactivated {
+		return nil
+	}
+	user.Deactivated = deactivated
+	return user.Save()
+}
+
+// SetBlocked помечает пользователя заблокировавшим (или разблокировавшим) бота и сохраняет.
+func (user *User) SetBlocked(blocked bool) error {
+	if user.Blocked == blocked {
+		return nil
+	}
+	user.Blocked = blocked
+	return user.Save()
+}
+
 func NewUser(path string) (*User, error) {
 	user := User{
 		path: path,
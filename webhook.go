@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TelegramNetworks - сети, из которых Telegram присылает webhook'и (https://core.telegram.org/bots/webhooks)
+var TelegramNetworks = []string{`149.154.160.0/20`, `91.108.4.0/22`}
+
+// SetWebhook https://core.telegram.org/bots/api#setwebhook
+type SetWebhook struct {
+	Url         string `json:"url"`
+	SecretToken string `json:"secret_token,omitempty"`
+}
+
+// newSecretToken - случайный secret_token для setWebhook, если в конфиге он не задан.
+func newSecretToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		Log.Error(`Can't generate webhook secret token`, `error`, err.Error())
+		return ``
+	}
+	return hex.EncodeToString(b)
+}
+
+// WebhookSecretToken - secret_token из конфига, а если его нет - сгенерированный при старте. Без него webhook принимал
+// бы апдейты от кого угодно.
+func (core *Core) WebhookSecretToken() string {
+	config := core.ConfigFile.Get().Telegram
+	if config.SecretToken != `` || config.Mode != `webhook` {
+		return config.SecretToken
+	}
+	return core.generatedSecretToken
+}
+
+func (core *Core) SetWebhook() error {
+	config := core.ConfigFile.Get().Telegram
+	payload := SetWebhook{
+		Url:         config.Webhook,
+		SecretToken: core.WebhookSecretToken(),
+	}
+	if payload.SecretToken == `` && !config.IpAllowlist {
+		Log.Warn(`Webhook accepts updates without authentication, set telegram.secret_token or telegram.ip_allowlist`)
+	}
+	if err := core.TelegramApi.RequestWrapper(`setWebhook`, payload, nil); err != nil {
+		PrometheusErrors.With(prometheus.Labels{`action`: `telegram_request`}).Inc()
+		return err
+	}
+	return nil
+}
+
+// RemoteIp возвращает адрес клиента, учитывая заголовок от reverse proxy, если он задан в конфиге.
+func (core *Core) RemoteIp(r *http.Request) net.IP {
+	if header := core.ConfigFile.Get().RealIpHeader; header != `` {
+		// В X-Forwarded-For каждый proxy дописывает адрес в конец, а начало присылает сам клиент и может подделать.
+		// Поэтому берем последний адрес - его добавил наш proxy.
+		values := r.Header.Values(header)
+		if len(values) == 0 {
+			return nil
+		}
+		addresses := strings.Split(values[len(values)-1], `,`)
+		return net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1]))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return net.ParseIP(r.RemoteAddr)
+	}
+	return net.ParseIP(host)
+}
+
+func (core *Core) IsAllowedIp(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range core.ConfigFile.Get().Telegram.AllowedNetworks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			Log.Error(`Bad allowed network`, `network`, network, `error`, err.Error())
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyTelegramRequest проверяет, что запрос на webhook действительно пришел от Telegram.
+func (core *Core) VerifyTelegramRequest(r *http.Request) error {
+	config := core.ConfigFile.Get().Telegram
+	if secretToken := core.WebhookSecretToken(); secretToken != `` {
+		token := r.Header.Get(`X-Telegram-Bot-Api-Secret-Token`)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+			PrometheusRejectedRequests.With(prometheus.Labels{`handler`: `telegram`, `reason`: `secret_token`}).Inc()
+			return errors.New(`wrong secret token`)
+		}
+	}
+	if config.IpAllowlist {
+		if ip := core.RemoteIp(r); !core.IsAllowedIp(ip) {
+			PrometheusRejectedRequests.With(prometheus.Labels{`handler`: `telegram`, `reason`: `ip`}).Inc()
+			return errors.New(`not allowed IP ` + ip.String())
+		}
+	}
+	return nil
+}
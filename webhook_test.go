@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteIp(t *testing.T) {
+	tests := []struct {
+		header   string
+		values   []string
+		expected string
+	}{
+		{``, nil, `192.0.2.1`},
+		{`X-Real-IP`, []string{`203.0.113.5`}, `203.0.113.5`},
+		// Первый адрес прислал клиент, последний - дописал proxy
+		{`X-Forwarded-For`, []string{`149.154.160.1, 203.0.113.5`}, `203.0.113.5`},
+		{`X-Forwarded-For`, []string{`149.154.160.1`, `203.0.113.5`}, `203.0.113.5`},
+		{`X-Forwarded-For`, nil, `<nil>`},
+	}
+	for _, test := range tests {
+		core := newTestCore(t, NewFakeTelegram(`test_bot`), func(config *Config) {
+			config.RealIpHeader = test.header
+		})
+		r := httptest.NewRequest(http.MethodPost, `/`, nil)
+		r.RemoteAddr = `192.0.2.1:1234`
+		for _, value := range test.values {
+			r.Header.Add(test.header, value)
+		}
+		if ip := core.RemoteIp(r).String(); ip != test.expected {
+			t.Errorf("%s %v: got %s, expected %s", test.header, test.values, ip, test.expected)
+		}
+	}
+}